@@ -5,49 +5,77 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"net/netip"
 	"os"
-	"reflect"
 	"regexp"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/alecthomas/kong"
 	"github.com/bluenviron/gomavlib/v4"
 	"github.com/bluenviron/gomavlib/v4/pkg/dialect"
+	"github.com/bluenviron/gomavlib/v4/pkg/dialects/ardupilotmega"
 	"github.com/bluenviron/gomavlib/v4/pkg/dialects/common"
 	"github.com/bluenviron/gomavlib/v4/pkg/message"
 
+	"github.com/bluenviron/mavp2p/pkg/acl"
+	"github.com/bluenviron/mavp2p/pkg/bonder"
+	"github.com/bluenviron/mavp2p/pkg/converter"
+	"github.com/bluenviron/mavp2p/pkg/crcverifier"
+	"github.com/bluenviron/mavp2p/pkg/decimator"
 	"github.com/bluenviron/mavp2p/pkg/dumper"
 	"github.com/bluenviron/mavp2p/pkg/errorman"
+	"github.com/bluenviron/mavp2p/pkg/heartbeat"
+	"github.com/bluenviron/mavp2p/pkg/hooks"
+	"github.com/bluenviron/mavp2p/pkg/linkstats"
+	"github.com/bluenviron/mavp2p/pkg/logger"
+	"github.com/bluenviron/mavp2p/pkg/loopdetector"
 	"github.com/bluenviron/mavp2p/pkg/messageman"
+	"github.com/bluenviron/mavp2p/pkg/pipeline"
+	"github.com/bluenviron/mavp2p/pkg/printer"
+	"github.com/bluenviron/mavp2p/pkg/registry"
+	"github.com/bluenviron/mavp2p/pkg/shaper"
+	"github.com/bluenviron/mavp2p/pkg/streamconf"
+	"github.com/bluenviron/mavp2p/pkg/target"
+	"github.com/bluenviron/mavp2p/pkg/traffic"
+	"github.com/bluenviron/mavp2p/pkg/tui"
+	"github.com/bluenviron/mavp2p/pkg/writer"
 )
 
 var version = "v0.0.0"
 
 var (
-	reArgs   = regexp.MustCompile("^([a-z]+):(.+)$")
+	reArgs   = regexp.MustCompile("^(?:([A-Za-z0-9_-]+)=)?([a-z]+):(.+)$")
 	reSerial = regexp.MustCompile("^(.+?):([0-9]+)$")
 )
 
 // decode/encode only a minimal set of messages.
 // other messages change too frequently and cannot be integrated into a static tool.
-func generateDialect(hbDisable bool, streamreqDisable bool) *dialect.Dialect {
+func generateDialect(passthrough bool) *dialect.Dialect {
+	// in pass-through mode, frames are not decoded and are forwarded as they are
+	if passthrough {
+		return &dialect.Dialect{Version: 3}
+	}
+
 	msgs := []message.Message{}
 
 	// add all messages with the TargetSystem and TargetComponent fields
-	var zero reflect.Value
+	targets := &target.Extractor{Dialect: common.Dialect}
+	targets.Initialize() //nolint:errcheck
 	for _, msg := range common.Dialect.Messages {
-		rv := reflect.ValueOf(msg).Elem()
-		if rv.FieldByName("TargetSystem") != zero && rv.FieldByName("TargetComponent") != zero {
+		if targets.HasTarget(msg.GetID()) {
 			msgs = append(msgs, msg)
 		}
 	}
 
-	if !hbDisable || !streamreqDisable {
-		msgs = append(msgs, &common.MessageHeartbeat{})
-	}
+	// heartbeats and autopilot versions are used to build the component registry
+	msgs = append(msgs, &common.MessageHeartbeat{}, &common.MessageAutopilotVersion{})
+
+	// radio status is used for link statistics and flow control
+	msgs = append(msgs, &common.MessageRadioStatus{})
 
 	return &dialect.Dialect{Version: 3, Messages: msgs}
 }
@@ -114,64 +142,525 @@ var endpointTypes = map[string]endpointType{
 	},
 }
 
-func generateEndpointConfs(endpoints []string) ([]gomavlib.Endpoint, error) {
+// generateEndpointConfs returns endpoint configurations,
+// together with a map that allows to find them by name or by definition.
+func generateEndpointConfs(endpoints []string) ([]gomavlib.Endpoint, map[string]gomavlib.Endpoint, error) {
 	if len(endpoints) < 1 {
-		return nil, fmt.Errorf("at least one endpoint is required")
+		return nil, nil, fmt.Errorf("at least one endpoint is required")
 	}
 
 	econfs := make([]gomavlib.Endpoint, len(endpoints))
+	names := make(map[string]gomavlib.Endpoint)
 
 	for i, e := range endpoints {
 		matches := reArgs.FindStringSubmatch(e)
 		if matches == nil {
-			return nil, fmt.Errorf("invalid endpoint: %s", e)
+			return nil, nil, fmt.Errorf("invalid endpoint: %s", e)
 		}
-		key, args := matches[1], matches[2]
+		name, key, args := matches[1], matches[2], matches[3]
 
 		etype, ok := endpointTypes[key]
 		if !ok {
-			return nil, fmt.Errorf("invalid endpoint: %s", e)
+			return nil, nil, fmt.Errorf("invalid endpoint: %s", e)
 		}
 
 		conf, err := etype.make(args)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		econfs[i] = conf
+
+		names[key+":"+args] = conf
+
+		if name != "" {
+			if _, ok = names[name]; ok {
+				return nil, nil, fmt.Errorf("duplicate endpoint name: %s", name)
+			}
+			names[name] = conf
+		}
+	}
+
+	return econfs, names, nil
+}
+
+func findEndpoint(names map[string]gomavlib.Endpoint, name string) (gomavlib.Endpoint, error) {
+	e, ok := names[name]
+	if !ok {
+		return nil, fmt.Errorf("endpoint not found: %s", name)
+	}
+	return e, nil
+}
+
+func generateBondGroups(bonds []string, names map[string]gomavlib.Endpoint) ([][]gomavlib.Endpoint, error) {
+	groups := make([][]gomavlib.Endpoint, len(bonds))
+	bonded := make(map[gomavlib.Endpoint]struct{})
+
+	for i, bond := range bonds {
+		members := strings.Split(bond, ",")
+		if len(members) < 2 {
+			return nil, fmt.Errorf("a bond requires at least two endpoints: %s", bond)
+		}
+
+		for _, name := range members {
+			e, err := findEndpoint(names, name)
+			if err != nil {
+				return nil, err
+			}
+
+			if _, ok := bonded[e]; ok {
+				return nil, fmt.Errorf("endpoint is part of multiple bonds: %s", name)
+			}
+			bonded[e] = struct{}{}
+
+			groups[i] = append(groups[i], e)
+		}
+	}
+
+	return groups, nil
+}
+
+func generateEndpointNodeTimeouts(
+	entries []string,
+	names map[string]gomavlib.Endpoint,
+) (map[gomavlib.Endpoint]time.Duration, error) {
+	timeouts := make(map[gomavlib.Endpoint]time.Duration)
+
+	for _, entry := range entries {
+		i := strings.LastIndex(entry, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid endpoint node timeout: %s", entry)
+		}
+
+		e, err := findEndpoint(names, entry[:i])
+		if err != nil {
+			return nil, err
+		}
+
+		timeout, err := time.ParseDuration(entry[i+1:])
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid endpoint node timeout: %s", entry)
+		}
+
+		timeouts[e] = timeout
+	}
+
+	return timeouts, nil
+}
+
+func generateStaticRoutes(
+	entries []string,
+	names map[string]gomavlib.Endpoint,
+) ([]messageman.StaticRoute, error) {
+	routes := make([]messageman.StaticRoute, 0, len(entries))
+
+	for _, entry := range entries {
+		target, name, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid route: %s", entry)
+		}
+
+		sysid, compid, hasCompid := strings.Cut(target, "/")
+
+		var r messageman.StaticRoute
+
+		tmp, err := strconv.ParseUint(sysid, 10, 8)
+		if err != nil || tmp == 0 {
+			return nil, fmt.Errorf("invalid route: %s", entry)
+		}
+		r.SystemID = byte(tmp)
+
+		if hasCompid {
+			tmp, err = strconv.ParseUint(compid, 10, 8)
+			if err != nil || tmp == 0 {
+				return nil, fmt.Errorf("invalid route: %s", entry)
+			}
+			r.ComponentID = byte(tmp)
+		}
+
+		r.Endpoint, err = findEndpoint(names, name)
+		if err != nil {
+			return nil, err
+		}
+
+		routes = append(routes, r)
+	}
+
+	return routes, nil
+}
+
+// findMessageID returns the ID of a message of the common dialect,
+// given its name or its numeric ID.
+func findMessageID(name string) (uint32, error) {
+	for _, msg := range common.Dialect.Messages {
+		if printer.MessageName(msg) == strings.ToUpper(name) {
+			return msg.GetID(), nil
+		}
+	}
+
+	tmp, err := strconv.ParseUint(name, 10, 24)
+	if err != nil {
+		return 0, fmt.Errorf("message not found in dialect: %s", name)
+	}
+	return uint32(tmp), nil
+}
+
+func generateStreamIntervals(entries []string) ([]streamconf.Interval, error) {
+	intervals := make([]streamconf.Interval, 0, len(entries))
+
+	for _, entry := range entries {
+		name, rate, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid stream: %s", entry)
+		}
+
+		i := streamconf.Interval{Name: strings.ToUpper(name)}
+
+		var err error
+		i.MessageID, err = findMessageID(name)
+		if err != nil {
+			return nil, err
+		}
+
+		i.Rate, err = strconv.ParseFloat(rate, 64)
+		if err != nil || i.Rate < 0 {
+			return nil, fmt.Errorf("invalid stream: %s", entry)
+		}
+
+		intervals = append(intervals, i)
+	}
+
+	return intervals, nil
+}
+
+func generateDecimationLimits(
+	entries []string,
+	names map[string]gomavlib.Endpoint,
+) ([]decimator.Limit, error) {
+	limits := make([]decimator.Limit, 0, len(entries))
+
+	for _, entry := range entries {
+		i := strings.LastIndex(entry, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid decimation: %s", entry)
+		}
+
+		j := strings.LastIndex(entry[:i], "=")
+		if j < 0 {
+			return nil, fmt.Errorf("invalid decimation: %s", entry)
+		}
+
+		var l decimator.Limit
+		var err error
+
+		l.Endpoint, err = findEndpoint(names, entry[:j])
+		if err != nil {
+			return nil, err
+		}
+
+		l.MessageID, err = findMessageID(entry[j+1 : i])
+		if err != nil {
+			return nil, err
+		}
+
+		l.Rate, err = strconv.ParseFloat(entry[i+1:], 64)
+		if err != nil || l.Rate <= 0 {
+			return nil, fmt.Errorf("invalid decimation: %s", entry)
+		}
+
+		limits = append(limits, l)
+	}
+
+	return limits, nil
+}
+
+func generateShapingBudgets(
+	entries []string,
+	names map[string]gomavlib.Endpoint,
+) ([]shaper.Budget, error) {
+	budgets := make([]shaper.Budget, 0, len(entries))
+
+	for _, entry := range entries {
+		i := strings.LastIndex(entry, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid bandwidth budget: %s", entry)
+		}
+
+		e, err := findEndpoint(names, entry[:i])
+		if err != nil {
+			return nil, err
+		}
+
+		rate, err := strconv.Atoi(entry[i+1:])
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("invalid bandwidth budget: %s", entry)
+		}
+
+		budgets = append(budgets, shaper.Budget{Endpoint: e, Rate: rate})
+	}
+
+	return budgets, nil
+}
+
+func parsePrefixes(entry string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+
+	for _, s := range strings.Split(entry, ",") {
+		if strings.Contains(s, "/") {
+			p, err := netip.ParsePrefix(s)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, p.Masked())
+			continue
+		}
+
+		a, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(a, a.BitLen()))
+	}
+
+	return prefixes, nil
+}
+
+func generateAccessRules(
+	allow []string,
+	deny []string,
+	maxClients []string,
+	uniqueSysids []string,
+	names map[string]gomavlib.Endpoint,
+) ([]acl.Rule, error) {
+	var rules []acl.Rule
+	indexes := make(map[gomavlib.Endpoint]int)
+
+	getRule := func(name string) (*acl.Rule, error) {
+		e, err := findEndpoint(names, name)
+		if err != nil {
+			return nil, err
+		}
+
+		switch e.(type) {
+		case *gomavlib.EndpointTCPServer, *gomavlib.EndpointUDPServer:
+		default:
+			return nil, fmt.Errorf("access control is available for tcps and udps endpoints only: %s", name)
+		}
+
+		i, ok := indexes[e]
+		if !ok {
+			i = len(rules)
+			indexes[e] = i
+			rules = append(rules, acl.Rule{Endpoint: e})
+		}
+		return &rules[i], nil
+	}
+
+	for _, entry := range allow {
+		i := strings.LastIndex(entry, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid allow list: %s", entry)
+		}
+
+		prefixes, err := parsePrefixes(entry[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid allow list: %s", entry)
+		}
+
+		r, err := getRule(entry[:i])
+		if err != nil {
+			return nil, err
+		}
+		r.Allow = append(r.Allow, prefixes...)
+	}
+
+	for _, entry := range deny {
+		i := strings.LastIndex(entry, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid deny list: %s", entry)
+		}
+
+		prefixes, err := parsePrefixes(entry[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid deny list: %s", entry)
+		}
+
+		r, err := getRule(entry[:i])
+		if err != nil {
+			return nil, err
+		}
+		r.Deny = append(r.Deny, prefixes...)
+	}
+
+	for _, entry := range maxClients {
+		i := strings.LastIndex(entry, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid client limit: %s", entry)
+		}
+
+		n, err := strconv.Atoi(entry[i+1:])
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid client limit: %s", entry)
+		}
+
+		r, err := getRule(entry[:i])
+		if err != nil {
+			return nil, err
+		}
+		r.MaxClients = n
+	}
+
+	for _, name := range uniqueSysids {
+		r, err := getRule(name)
+		if err != nil {
+			return nil, err
+		}
+		r.UniqueSystemIDs = true
+	}
+
+	return rules, nil
+}
+
+func generateOutVersions(
+	entries []string,
+	names map[string]gomavlib.Endpoint,
+) ([]converter.OutVersion, error) {
+	versions := make([]converter.OutVersion, 0, len(entries))
+
+	for _, entry := range entries {
+		i := strings.LastIndex(entry, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid version: %s", entry)
+		}
+
+		e, err := findEndpoint(names, entry[:i])
+		if err != nil {
+			return nil, err
+		}
+
+		var version gomavlib.Version
+		switch entry[i+1:] {
+		case "1":
+			version = gomavlib.V1
+		case "2":
+			version = gomavlib.V2
+		default:
+			return nil, fmt.Errorf("invalid version: %s", entry)
+		}
+
+		versions = append(versions, converter.OutVersion{Endpoint: e, Version: version})
 	}
 
-	return econfs, nil
+	return versions, nil
 }
 
 var cli struct {
-	Version            bool `help:"Print version."`
-	Quiet              bool `short:"q" help:"Suppress info messages."`
-	Print              bool `help:"Print routed frames."`
-	PrintErrors        bool
-	ReadTimeout        time.Duration `help:"Timeout of read operations." default:"10s"`
-	WriteTimeout       time.Duration `help:"Timeout of write operations." default:"10s"`
-	IdleTimeout        time.Duration `help:"Disconnect idle connections after a timeout." default:"60s"`
-	HbDisable          bool          `help:"Disable heartbeats."`
-	HbVersion          int           `enum:"1,2" help:"Mavlink version of heartbeats." default:"1"`
-	HbSystemid         int           `default:"125"`
-	HbComponentid      int           `help:"Component ID of heartbeats." default:"191"`
-	HbPeriod           int           `help:"Period of heartbeats." default:"5"`
-	StreamreqDisable   bool
-	StreamreqFrequency int           `help:"Stream frequency to request." default:"4"`
-	Dump               bool          `help:"Dump telemetry to disk"`
-	DumpPath           string        `default:"dump/2006-01-02_15-04-05.tlog"`
-	DumpDuration       time.Duration `help:"Maximum duration of each dump segment" default:"1h"`
-	Endpoints          []string      `arg:"" optional:""`
+	Version              bool   `help:"Print version."`
+	Quiet                bool   `short:"q" help:"Suppress info messages."`
+	LogLevel             string `enum:"debug,info,warn,error" help:"Log level." default:"info"`
+	LogFormat            string `enum:"text,json" help:"Log format." default:"text"`
+	LogSubsystemLevel    []string
+	Print                bool     `help:"Print received frames in a readable format." xor:"print"`
+	PrintMessage         []string `help:"Print only these messages, i.e. HEARTBEAT. It can be specified multiple times."`
+	PrintSysid           []int    `help:"Print only messages sent by these system IDs. It can be specified multiple times."`
+	PrintChannel         []string `help:"Print only messages received from these endpoints. It can be specified multiple times."`
+	PrintDialect         string   `enum:"routing,common,ardupilotmega" default:"routing"`
+	Tui                  bool     `help:"Show a live view of channels, nodes, message rates and errors in place of logs." xor:"print"`
+	PrintErrors          bool
+	ErrorSummaryPeriod   time.Duration `help:"Period of the parse error summary." default:"5s"`
+	ErrorUnknownMessages bool
+	ErrorHexDump         bool
+	ReadTimeout          time.Duration `help:"Timeout of read operations." default:"10s"`
+	WriteTimeout         time.Duration `help:"Timeout of write operations." default:"10s"`
+	IdleTimeout          time.Duration `help:"Disconnect idle connections after a timeout." default:"60s"`
+	WriteQueueSize       int           `help:"Maximum number of outbound frames queued for each channel." default:"256"`
+	WriteQueuePolicy     string        `enum:"drop-oldest,drop-newest,disconnect" default:"drop-oldest"`
+	PipelineQueueSize    int           `default:"1024"`
+	HbDisable            bool          `help:"Disable heartbeats."`
+	HbVersion            int           `enum:"1,2" help:"Mavlink version of heartbeats." default:"1"`
+	HbSystemid           int           `default:"125"`
+	HbComponentid        int           `help:"Component ID of heartbeats." default:"191"`
+	HbPeriod             int           `help:"Period of heartbeats." default:"5"`
+	HbType               uint8         `help:"MAV_TYPE of heartbeats." default:"6"`
+	HbAutopilot          uint8         `help:"MAV_AUTOPILOT of heartbeats." default:"0"`
+	HbSystemStatus       uint8         `help:"MAV_STATE of heartbeats." default:"4"`
+	HbDisableEndpoint    []string      `help:"Do not send heartbeats to these endpoints. It can be specified multiple times."`
+	HbYield              bool
+	StreamreqDisable     bool
+	StreamreqFrequency   int           `help:"Stream frequency to request." default:"4"`
+	Stream               []string      `sep:"none"`
+	StreamAckTimeout     time.Duration `help:"Timeout of stream configuration commands." default:"1s"`
+	StreamRetries        int           `help:"Number of times stream configuration commands are sent again when they are not acknowledged." default:"3"`
+	StreamArbitrate      bool
+	Passthrough          bool
+	PassthroughVerifyCrc bool
+	NodeTimeout          time.Duration `help:"Remove remote nodes after this period of inactivity." default:"30s"`
+	NodeTimeoutEndpoint  []string      `sep:"none"`
+	NodeSweepPeriod      time.Duration `help:"Period of the check of inactive nodes." default:"10s"`
+	NodeStickyVehicles   bool
+	Route                []string `sep:"none"`
+	Decimate             []string `sep:"none"`
+	Shape                []string `sep:"none"`
+	ShapeQueueSize       int      `help:"Maximum number of frames in the queue of each priority class of shaped endpoints." default:"64"`
+	RadioFlowControl     bool
+	Convert              []string      `sep:"none"`
+	Allow                []string      `sep:"none"`
+	Deny                 []string      `sep:"none"`
+	MaxClients           []string      `sep:"none"`
+	UniqueSysid          []string      `help:"Reject clients of these tcps or udps endpoints that send frames with system IDs already used by another client. It can be specified multiple times."`
+	LoopdetectDisable    bool          `help:"Disable detection of routing loops."`
+	LoopdetectWindow     time.Duration `default:"2s"`
+	Bond                 []string      `sep:"none"`
+	BondPolicy           string        `enum:"all,best,failover" default:"all"`
+	BondTimeout          time.Duration `help:"Consider bonded endpoints unhealthy when they do not receive heartbeats within this timeout." default:"5s"`
+	BondHysteresis       time.Duration `default:"10s"`
+	Linkstats            bool          `help:"Print packet loss and link quality statistics periodically."`
+	LinkstatsPeriod      time.Duration `help:"Period of link statistics." default:"10s"`
+	Traffic              bool          `help:"Print frames and bytes received and sent by each channel periodically."`
+	TrafficPeriod        time.Duration `help:"Period of traffic statistics." default:"10s"`
+	Dump                 bool          `help:"Dump telemetry to disk"`
+	DumpPath             string        `default:"dump/2006-01-02_15-04-05.tlog"`
+	DumpDuration         time.Duration `help:"Maximum duration of each dump segment" default:"1h"`
+	HookCommand          string
+	HookURL              string `name:"hook-url" help:"URL that receives router events in JSON POST requests."`
+	HookEvent            []string
+	HookTimeout          time.Duration `help:"Timeout of hook commands and requests." default:"10s"`
+	HookMaxConcurrent    int           `help:"Maximum number of hook commands and requests running at the same time." default:"4"`
+	HookErrorThreshold   uint64        `default:"100"`
+	Endpoints            []string      `arg:"" optional:""`
 }
 
 type program struct {
-	ctx        context.Context
-	ctxCancel  func()
-	wg         sync.WaitGroup
-	node       *gomavlib.Node
-	errorMan   *errorman.Manager
-	messageMan *messageman.Manager
-	dumper     *dumper.Dumper
+	ctx          context.Context
+	ctxCancel    func()
+	wg           sync.WaitGroup
+	logger       *logger.Logger
+	log          *slog.Logger
+	node         *gomavlib.Node
+	hooks        *hooks.Hooks
+	heartbeat    *heartbeat.Emitter
+	errorMan     *errorman.Manager
+	messageMan   *messageman.Manager
+	bonder       *bonder.Bonder
+	decimator    *decimator.Decimator
+	shaper       *shaper.Shaper
+	converter    *converter.Converter
+	acl          *acl.Controller
+	writer       *writer.Writer
+	loopDetector *loopdetector.Detector
+	crcVerifier  *crcverifier.Verifier
+	linkStats    *linkstats.Tracker
+	registry     *registry.Registry
+	streamConf   *streamconf.Configurator
+	printer      *printer.Printer
+	traffic      *traffic.Accountant
+	tui          *tui.TUI
+	dumper       *dumper.Dumper
+	routeStage   *pipeline.Stage
+	statsStage   *pipeline.Stage
+	exportStage  *pipeline.Stage
+
+	// channels removed from routing. Accessed by the route stage only.
+	detached map[*gomavlib.Channel]struct{}
 }
 
 func newProgram(args []string) (*program, error) {
@@ -180,8 +669,60 @@ func newProgram(args []string) (*program, error) {
 		kong.UsageOnError(),
 		kong.ValueFormatter(func(value *kong.Value) string {
 			switch value.Name {
+			case "print-dialect":
+				return "Dialect used to decode printed messages: the minimal dialect used for routing (routing)," +
+					" the common dialect (common) or the ardupilotmega dialect (ardupilotmega)."
+
 			case "print-errors":
-				return "Print parse errors singularly, instead of printing only their quantity every 5 seconds."
+				return "Print parse errors singularly, instead of printing a periodic summary grouped by channel and category."
+
+			case "error-unknown-messages":
+				return "Count messages outside the ardupilotmega dialect as parse errors, in the unknown_message category." +
+					" They are routed anyway."
+
+			case "error-hex-dump":
+				return "Include a hex dump of the offending frame in errors printed singularly." +
+					" Bytes are available for unknown messages and, in pass-through mode, for frames with invalid checksums," +
+					" while they are not available for errors raised while reading frames."
+
+			case "log-subsystem-level":
+				return "Override the log level of a subsystem, in the subsystem=level format." +
+					" Subsystems are main, messageman, registry, streamconf, heartbeat, hooks, errorman, bonder, loopdetector," +
+					" linkstats, traffic, shaper, converter, writer, acl, pipeline, crcverifier, dumper."
+
+			case "convert":
+				return "Mavlink version of frames sent to an endpoint, in the endpoint=version format, i.e. tracker=1." +
+					" Frames with a different version are converted. Extensions are removed when converting to Mavlink 1," +
+					" while messages with an ID greater than 255 or outside the ardupilotmega dialect are discarded." +
+					" It can be specified multiple times."
+
+			case "allow":
+				return "Comma-separated list of addresses or networks that can connect to a tcps or udps endpoint," +
+					" in the endpoint=networks format, i.e. gcs=10.0.0.0/8,192.168.1.5. Other clients are rejected." +
+					" Frames are not routed to and from rejected clients, whose connections stay open" +
+					" until they disconnect or become idle. It can be specified multiple times."
+
+			case "deny":
+				return "Comma-separated list of addresses or networks that cannot connect to a tcps or udps endpoint," +
+					" in the endpoint=networks format, i.e. gcs=10.0.0.66. It can be specified multiple times."
+
+			case "max-clients":
+				return "Maximum number of clients of a tcps or udps endpoint, in the endpoint=count format, i.e. gcs=4." +
+					" Additional clients are rejected. It can be specified multiple times."
+
+			case "passthrough":
+				return "Forward frames without decoding them, including messages of unknown dialects." +
+					" Targets are read from payloads of messages of the ardupilotmega dialect." +
+					" Heartbeats, stream requests, stream configuration and radio flow control are not available."
+
+			case "passthrough-verify-crc":
+				return "In pass-through mode, discard frames with an invalid checksum." +
+					" Only messages of the ardupilotmega dialect can be verified."
+
+			case "hb-yield":
+				return "Stop sending heartbeats to a channel when another router is present on it," +
+					" i.e. when heartbeats with the same type and component ID but a lower system ID are received." +
+					" When two routers are on the same link, the one with the higher system ID stops."
 
 			case "hb-systemid":
 				return "System ID of heartbeats. It is recommended to set a different system id for each router in the network."
@@ -192,14 +733,97 @@ func newProgram(args []string) (*program, error) {
 					" This task is usually delegated to the router," +
 					" in order to avoid conflicts when multiple ground stations are active."
 
+			case "write-queue-policy":
+				return "Policy used when the outbound queue of a channel is full: discard the oldest frame (drop-oldest)," +
+					" discard the new frame (drop-newest) or discard the queue and stop routing frames to and from the channel" +
+					" until it is closed (disconnect)."
+
+			case "pipeline-queue-size":
+				return "Maximum number of events queued for each stage of the pipeline." +
+					" Routing waits for its queue to be available, in order not to lose frames," +
+					" while statistics, printing and dumping discard frames when their queue is full," +
+					" and report how many frames have been discarded."
+
+			case "stream":
+				return "Rate of a message emitted by autopilots, in the name=hz format, i.e. ATTITUDE=20." +
+					" Rates are set with MAV_CMD_SET_MESSAGE_INTERVAL when autopilots appear or reboot." +
+					" A zero rate disables the message. It can be specified multiple times."
+
+			case "stream-arbitrate":
+				return "Intercept MAV_CMD_SET_MESSAGE_INTERVAL commands sent by ground stations to autopilots," +
+					" merge them by taking the maximum requested rate of each message, apply the result" +
+					" and acknowledge commands on behalf of autopilots." +
+					" MAV_CMD_REQUEST_MESSAGE commands sent again by the same ground station with the same parameters" +
+					" are forwarded once per second."
+
 			case "endpoints":
 				desc := "Space-separated list of endpoints. At least one endpoint is required. " +
 					"Possible endpoints types are:\n\n"
 				for k, etype := range endpointTypes {
 					desc += fmt.Sprintf("%s:%s (%s)\n\n", k, etype.args, etype.desc)
 				}
+				desc += "Endpoints can be named with the name=type:args syntax, " +
+					"in order to be referred by other flags."
 				return desc
 
+			case "loopdetect-window":
+				return "Frames that are received again from a different channel within this window" +
+					" are considered part of a routing loop and are discarded."
+
+			case "node-timeout-endpoint":
+				return "Override the inactivity timeout of nodes reachable through an endpoint," +
+					" in the endpoint=duration format. It can be specified multiple times."
+
+			case "node-sticky-vehicles":
+				return "Keep routes to vehicles even after a period of inactivity." +
+					" They are removed only when their channel is closed."
+
+			case "route":
+				return "Static route, in the sysid=endpoint or sysid/compid=endpoint format." +
+					" Messages addressed to the node are routed to the endpoint when the node has not been seen yet." +
+					" It can be specified multiple times."
+
+			case "decimate":
+				return "Maximum rate of a message sent to an endpoint, in the endpoint=name=hz format, i.e. relay=ATTITUDE=4." +
+					" Excess frames are discarded, except the latest one, that is sent as soon as the rate allows it." +
+					" It can be specified multiple times."
+
+			case "shape":
+				return "Bandwidth budget of an endpoint, in the endpoint=bytes_per_second format, i.e. radio=5000." +
+					" Outbound frames are queued and sent by priority: commands, acknowledgements and heartbeats first," +
+					" then parameters and missions, then telemetry, then file and log transfers." +
+					" When queues are full, the oldest telemetry frames are discarded. It can be specified multiple times."
+
+			case "radio-flow-control":
+				return "Track RADIO_STATUS sent by radios attached to serial endpoints," +
+					" and slow down or pause telemetry and file transfers when the radio buffer is getting full."
+
+			case "bond":
+				return "Comma-separated list of endpoints that are redundant paths to the same vehicle." +
+					" Inbound frames are deduplicated and outbound frames are routed according to the bond policy." +
+					" It can be specified multiple times."
+
+			case "bond-policy":
+				return "Policy used to route outbound frames to bonded endpoints:" +
+					" all healthy endpoints (all), the healthy endpoint with the lowest packet loss (best)" +
+					" or the healthy endpoint that comes first in the bond (failover)."
+
+			case "bond-hysteresis":
+				return "When the failover policy is in use, time after which an endpoint that recovered" +
+					" is preferred again to endpoints that come after it in the bond."
+
+			case "hook-command":
+				return "Shell command that is run when router events occur." +
+					" Event details are passed in environment variables (MAVP2P_EVENT, MAVP2P_CHANNEL, MAVP2P_SYSID, ...)."
+
+			case "hook-event":
+				return "Run hooks only for these events: " + strings.Join(hooks.EventTypes, ", ") + "." +
+					" It can be specified multiple times."
+
+			case "hook-error-threshold":
+				return "Fire the parse_errors event when the parse errors of a channel within the error summary period" +
+					" reach this threshold. Zero disables it."
+
 			case "dump-path":
 				return "Path of dump segments, in Golang's time.Format() format"
 
@@ -227,72 +851,509 @@ func newProgram(args []string) (*program, error) {
 		os.Exit(1)
 	}
 
-	endpointConfs, err := generateEndpointConfs(cli.Endpoints)
+	logLevel, err := logger.ParseLevel(cli.LogLevel)
 	if err != nil {
 		return nil, err
 	}
 
-	ctx, ctxCancel := context.WithCancel(context.Background())
-
-	p := &program{
-		ctx:       ctx,
-		ctxCancel: ctxCancel,
+	if cli.Quiet {
+		logLevel = max(logLevel, slog.LevelWarn)
 	}
 
-	dialect := generateDialect(cli.HbDisable, cli.StreamreqDisable)
-
-	p.node = &gomavlib.Node{
-		Endpoints: endpointConfs,
-		Dialect:   dialect,
-		OutVersion: func() gomavlib.Version {
-			if cli.HbVersion == 2 {
-				return gomavlib.V2
-			}
-			return gomavlib.V1
-		}(),
-		OutSystemID:            byte(cli.HbSystemid),
-		OutComponentID:         byte(cli.HbComponentid),
-		HeartbeatDisable:       cli.HbDisable,
-		HeartbeatPeriod:        (time.Duration(cli.HbPeriod) * time.Second),
-		StreamRequestEnable:    !cli.StreamreqDisable,
-		StreamRequestFrequency: cli.StreamreqFrequency,
-		ReadTimeout:            cli.ReadTimeout,
-		WriteTimeout:           cli.WriteTimeout,
-		IdleTimeout:            cli.IdleTimeout,
-	}
-	err = p.node.Initialize()
+	subsystemLevels, err := logger.ParseSubsystemLevels(cli.LogSubsystemLevel)
 	if err != nil {
-		ctxCancel()
 		return nil, err
 	}
 
-	p.errorMan = &errorman.Manager{
-		Ctx:               ctx,
-		Wg:                &p.wg,
-		PrintSingleErrors: cli.PrintErrors,
-	}
-	err = p.errorMan.Initialize()
+	endpointConfs, endpointNames, err := generateEndpointConfs(cli.Endpoints)
 	if err != nil {
-		ctxCancel()
-		p.wg.Wait()
-		p.node.Close()
 		return nil, err
 	}
 
-	p.messageMan = &messageman.Manager{
-		Ctx:              ctx,
-		Wg:               &p.wg,
-		StreamReqDisable: cli.StreamreqDisable,
-		Node:             p.node,
+	bondGroups, err := generateBondGroups(cli.Bond, endpointNames)
+	if err != nil {
+		return nil, err
 	}
-	err = p.messageMan.Initialize()
+
+	endpointNodeTimeouts, err := generateEndpointNodeTimeouts(cli.NodeTimeoutEndpoint, endpointNames)
 	if err != nil {
-		ctxCancel()
-		p.wg.Wait()
-		p.node.Close()
 		return nil, err
 	}
 
+	staticRoutes, err := generateStaticRoutes(cli.Route, endpointNames)
+	if err != nil {
+		return nil, err
+	}
+
+	streamIntervals, err := generateStreamIntervals(cli.Stream)
+	if err != nil {
+		return nil, err
+	}
+
+	decimationLimits, err := generateDecimationLimits(cli.Decimate, endpointNames)
+	if err != nil {
+		return nil, err
+	}
+
+	shapingBudgets, err := generateShapingBudgets(cli.Shape, endpointNames)
+	if err != nil {
+		return nil, err
+	}
+
+	outVersions, err := generateOutVersions(cli.Convert, endpointNames)
+	if err != nil {
+		return nil, err
+	}
+
+	accessRules, err := generateAccessRules(cli.Allow, cli.Deny, cli.MaxClients, cli.UniqueSysid, endpointNames)
+	if err != nil {
+		return nil, err
+	}
+
+	if cli.Passthrough {
+		if len(streamIntervals) != 0 || cli.StreamArbitrate || cli.RadioFlowControl {
+			return nil, fmt.Errorf("stream configuration and radio flow control are not available in pass-through mode")
+		}
+	} else if cli.PassthroughVerifyCrc {
+		return nil, fmt.Errorf("checksum verification requires pass-through mode")
+	}
+
+	ctx, ctxCancel := context.WithCancel(context.Background())
+
+	p := &program{
+		ctx:       ctx,
+		ctxCancel: ctxCancel,
+		detached:  make(map[*gomavlib.Channel]struct{}),
+	}
+
+	var logWriter io.Writer = os.Stderr
+	var logBuffer *tui.LogBuffer
+
+	// when the terminal UI is in use, logs are displayed inside it
+	if cli.Tui {
+		logBuffer = &tui.LogBuffer{}
+		logWriter = logBuffer
+	}
+
+	p.logger = &logger.Logger{
+		Writer: logWriter,
+		Format: func() logger.Format {
+			if cli.LogFormat == "json" {
+				return logger.FormatJSON
+			}
+			return logger.FormatText
+		}(),
+		Level:           logLevel,
+		SubsystemLevels: subsystemLevels,
+	}
+	err = p.logger.Initialize()
+	if err != nil {
+		ctxCancel()
+		return nil, err
+	}
+
+	p.log = p.logger.Subsystem("main")
+	slog.SetDefault(p.log)
+
+	dialect := generateDialect(cli.Passthrough)
+
+	// in pass-through mode, targets are read from payloads of known messages
+	targetDialect := dialect
+	if cli.Passthrough {
+		targetDialect = ardupilotmega.Dialect
+	}
+
+	p.node = &gomavlib.Node{
+		Endpoints: endpointConfs,
+		Dialect:   dialect,
+		OutVersion: func() gomavlib.Version {
+			if cli.HbVersion == 2 {
+				return gomavlib.V2
+			}
+			return gomavlib.V1
+		}(),
+		OutSystemID:    byte(cli.HbSystemid),
+		OutComponentID: byte(cli.HbComponentid),
+		// heartbeats are sent by the heartbeat emitter
+		HeartbeatDisable:       true,
+		StreamRequestEnable:    !cli.StreamreqDisable && !cli.Passthrough,
+		StreamRequestFrequency: cli.StreamreqFrequency,
+		ReadTimeout:            cli.ReadTimeout,
+		WriteTimeout:           cli.WriteTimeout,
+		IdleTimeout:            cli.IdleTimeout,
+	}
+	err = p.node.Initialize()
+	if err != nil {
+		ctxCancel()
+		return nil, err
+	}
+
+	if cli.HookCommand != "" || cli.HookURL != "" {
+		p.hooks = &hooks.Hooks{
+			Ctx:           ctx,
+			Wg:            &p.wg,
+			Command:       cli.HookCommand,
+			URL:           cli.HookURL,
+			Events:        cli.HookEvent,
+			Timeout:       cli.HookTimeout,
+			MaxConcurrent: cli.HookMaxConcurrent,
+			Log:           p.logger.Subsystem("hooks"),
+		}
+		err = p.hooks.Initialize()
+		if err != nil {
+			ctxCancel()
+			p.wg.Wait()
+			p.node.Close()
+			return nil, err
+		}
+	}
+
+	p.errorMan = &errorman.Manager{
+		Ctx:               ctx,
+		Wg:                &p.wg,
+		PrintSingleErrors: cli.PrintErrors,
+		SummaryPeriod:     cli.ErrorSummaryPeriod,
+		Log:               p.logger.Subsystem("errorman"),
+		Hooks:             p.hooks,
+		HooksThreshold:    cli.HookErrorThreshold,
+		HexDump:           cli.ErrorHexDump,
+	}
+	if cli.ErrorUnknownMessages {
+		p.errorMan.Dialect = ardupilotmega.Dialect
+	}
+	err = p.errorMan.Initialize()
+	if err != nil {
+		ctxCancel()
+		p.wg.Wait()
+		p.node.Close()
+		return nil, err
+	}
+
+	p.linkStats = &linkstats.Tracker{
+		Ctx:         ctx,
+		Wg:          &p.wg,
+		Print:       cli.Linkstats,
+		PrintPeriod: cli.LinkstatsPeriod,
+		Log:         p.logger.Subsystem("linkstats"),
+	}
+	err = p.linkStats.Initialize()
+	if err != nil {
+		ctxCancel()
+		p.wg.Wait()
+		p.node.Close()
+		return nil, err
+	}
+
+	if len(bondGroups) != 0 {
+		p.bonder = &bonder.Bonder{
+			Ctx:    ctx,
+			Wg:     &p.wg,
+			Groups: bondGroups,
+			Policy: func() bonder.Policy {
+				switch cli.BondPolicy {
+				case "best":
+					return bonder.PolicyBest
+				case "failover":
+					return bonder.PolicyFailover
+				}
+				return bonder.PolicyAll
+			}(),
+			HeartbeatTimeout: cli.BondTimeout,
+			Hysteresis:       cli.BondHysteresis,
+			LinkStats:        p.linkStats,
+			Hooks:            p.hooks,
+			Log:              p.logger.Subsystem("bonder"),
+		}
+		err = p.bonder.Initialize()
+		if err != nil {
+			ctxCancel()
+			p.wg.Wait()
+			p.node.Close()
+			return nil, err
+		}
+	}
+
+	p.registry = &registry.Registry{
+		Ctx:              ctx,
+		Wg:               &p.wg,
+		Timeout:          cli.NodeTimeout,
+		EndpointTimeouts: endpointNodeTimeouts,
+		StickyVehicles:   cli.NodeStickyVehicles,
+		OnEvent:          p.onRegistryEvent,
+		Log:              p.logger.Subsystem("registry"),
+	}
+	err = p.registry.Initialize()
+	if err != nil {
+		ctxCancel()
+		p.wg.Wait()
+		p.node.Close()
+		return nil, err
+	}
+
+	p.traffic = &traffic.Accountant{
+		Ctx:         ctx,
+		Wg:          &p.wg,
+		Dialect:     dialect,
+		Print:       cli.Traffic,
+		PrintPeriod: cli.TrafficPeriod,
+		Log:         p.logger.Subsystem("traffic"),
+	}
+	err = p.traffic.Initialize()
+	if err != nil {
+		ctxCancel()
+		p.wg.Wait()
+		p.node.Close()
+		return nil, err
+	}
+
+	if len(accessRules) != 0 {
+		p.acl = &acl.Controller{
+			Ctx:   ctx,
+			Wg:    &p.wg,
+			Rules: accessRules,
+			Log:   p.logger.Subsystem("acl"),
+		}
+		err = p.acl.Initialize()
+		if err != nil {
+			ctxCancel()
+			p.wg.Wait()
+			p.node.Close()
+			return nil, err
+		}
+	}
+
+	if len(outVersions) != 0 {
+		p.converter = &converter.Converter{
+			Ctx:         ctx,
+			Wg:          &p.wg,
+			OutVersions: outVersions,
+			Dialect:     ardupilotmega.Dialect,
+			Log:         p.logger.Subsystem("converter"),
+		}
+		err = p.converter.Initialize()
+		if err != nil {
+			ctxCancel()
+			p.wg.Wait()
+			p.node.Close()
+			return nil, err
+		}
+	}
+
+	p.writer = &writer.Writer{
+		Ctx:       ctx,
+		Wg:        &p.wg,
+		Node:      p.node,
+		QueueSize: cli.WriteQueueSize,
+		Policy: func() writer.Policy {
+			switch cli.WriteQueuePolicy {
+			case "drop-newest":
+				return writer.PolicyDropNewest
+			case "disconnect":
+				return writer.PolicyDisconnect
+			}
+			return writer.PolicyDropOldest
+		}(),
+		Traffic:   p.traffic,
+		Converter: p.converter,
+		Log:       p.logger.Subsystem("writer"),
+	}
+	err = p.writer.Initialize()
+	if err != nil {
+		ctxCancel()
+		p.wg.Wait()
+		p.node.Close()
+		return nil, err
+	}
+
+	if len(shapingBudgets) != 0 || cli.RadioFlowControl {
+		p.shaper = &shaper.Shaper{
+			Ctx:         ctx,
+			Wg:          &p.wg,
+			Budgets:     shapingBudgets,
+			Traffic:     p.traffic,
+			Writer:      p.writer,
+			QueueSize:   cli.ShapeQueueSize,
+			FlowControl: cli.RadioFlowControl,
+			Log:         p.logger.Subsystem("shaper"),
+		}
+		err = p.shaper.Initialize()
+		if err != nil {
+			ctxCancel()
+			p.wg.Wait()
+			p.node.Close()
+			return nil, err
+		}
+	}
+
+	if len(decimationLimits) != 0 {
+		p.decimator = &decimator.Decimator{
+			Ctx:    ctx,
+			Wg:     &p.wg,
+			Limits: decimationLimits,
+			Shaper: p.shaper,
+			Writer: p.writer,
+		}
+		err = p.decimator.Initialize()
+		if err != nil {
+			ctxCancel()
+			p.wg.Wait()
+			p.node.Close()
+			return nil, err
+		}
+	}
+
+	p.messageMan = &messageman.Manager{
+		Ctx:              ctx,
+		Wg:               &p.wg,
+		StreamReqDisable: cli.StreamreqDisable,
+		Node:             p.node,
+		Bonder:           p.bonder,
+		Decimator:        p.decimator,
+		Shaper:           p.shaper,
+		Writer:           p.writer,
+		Hooks:            p.hooks,
+		Dialect:          targetDialect,
+		Log:              p.logger.Subsystem("messageman"),
+		Registry:         p.registry,
+		SweepPeriod:      cli.NodeSweepPeriod,
+		StaticRoutes:     staticRoutes,
+	}
+	err = p.messageMan.Initialize()
+	if err != nil {
+		ctxCancel()
+		p.wg.Wait()
+		p.node.Close()
+		return nil, err
+	}
+
+	if !cli.HbDisable && !cli.Passthrough {
+		var disabledEndpoints []gomavlib.Endpoint
+		for _, name := range cli.HbDisableEndpoint {
+			e, err := findEndpoint(endpointNames, name)
+			if err != nil {
+				ctxCancel()
+				p.wg.Wait()
+				p.node.Close()
+				return nil, err
+			}
+			disabledEndpoints = append(disabledEndpoints, e)
+		}
+
+		p.heartbeat = &heartbeat.Emitter{
+			Ctx:               ctx,
+			Wg:                &p.wg,
+			MessageMan:        p.messageMan,
+			Period:            time.Duration(cli.HbPeriod) * time.Second,
+			SystemID:          byte(cli.HbSystemid),
+			ComponentID:       byte(cli.HbComponentid),
+			Type:              common.MAV_TYPE(cli.HbType),
+			Autopilot:         common.MAV_AUTOPILOT(cli.HbAutopilot),
+			SystemStatus:      common.MAV_STATE(cli.HbSystemStatus),
+			DisabledEndpoints: disabledEndpoints,
+			YieldToRouters:    cli.HbYield,
+			Log:               p.logger.Subsystem("heartbeat"),
+		}
+		err = p.heartbeat.Initialize()
+		if err != nil {
+			ctxCancel()
+			p.wg.Wait()
+			p.node.Close()
+			return nil, err
+		}
+	}
+
+	if len(streamIntervals) != 0 || cli.StreamArbitrate {
+		p.streamConf = &streamconf.Configurator{
+			Ctx:         ctx,
+			Wg:          &p.wg,
+			MessageMan:  p.messageMan,
+			SystemID:    byte(cli.HbSystemid),
+			ComponentID: byte(cli.HbComponentid),
+			Intervals:   streamIntervals,
+			AckTimeout:  cli.StreamAckTimeout,
+			Retries:     cli.StreamRetries,
+			Arbitrate:   cli.StreamArbitrate,
+			Log:         p.logger.Subsystem("streamconf"),
+		}
+		err = p.streamConf.Initialize()
+		if err != nil {
+			ctxCancel()
+			p.wg.Wait()
+			p.node.Close()
+			return nil, err
+		}
+	}
+
+	if cli.PassthroughVerifyCrc {
+		p.crcVerifier = &crcverifier.Verifier{
+			Ctx:      ctx,
+			Wg:       &p.wg,
+			Dialect:  ardupilotmega.Dialect,
+			Log:      p.logger.Subsystem("crcverifier"),
+			ErrorMan: p.errorMan,
+		}
+		err = p.crcVerifier.Initialize()
+		if err != nil {
+			ctxCancel()
+			p.wg.Wait()
+			p.node.Close()
+			return nil, err
+		}
+	}
+
+	if !cli.LoopdetectDisable {
+		p.loopDetector = &loopdetector.Detector{
+			Ctx:    ctx,
+			Wg:     &p.wg,
+			Window: cli.LoopdetectWindow,
+			Log:    p.logger.Subsystem("loopdetector"),
+		}
+		err = p.loopDetector.Initialize()
+		if err != nil {
+			ctxCancel()
+			p.wg.Wait()
+			p.node.Close()
+			return nil, err
+		}
+	}
+
+	if cli.Print {
+		var printChannels []gomavlib.Endpoint
+		for _, name := range cli.PrintChannel {
+			e, err := findEndpoint(endpointNames, name)
+			if err != nil {
+				ctxCancel()
+				p.wg.Wait()
+				p.node.Close()
+				return nil, err
+			}
+			printChannels = append(printChannels, e)
+		}
+
+		printDialect := dialect
+		switch cli.PrintDialect {
+		case "common":
+			printDialect = common.Dialect
+		case "ardupilotmega":
+			printDialect = ardupilotmega.Dialect
+		}
+
+		p.printer = &printer.Printer{
+			Writer:    os.Stdout,
+			Dialect:   printDialect,
+			Messages:  cli.PrintMessage,
+			SystemIDs: cli.PrintSysid,
+			Channels:  printChannels,
+		}
+		err = p.printer.Initialize()
+		if err != nil {
+			ctxCancel()
+			p.wg.Wait()
+			p.node.Close()
+			return nil, err
+		}
+	}
+
 	if cli.Dump {
 		p.dumper = &dumper.Dumper{
 			Ctx:          ctx,
@@ -300,6 +1361,7 @@ func newProgram(args []string) (*program, error) {
 			Dialect:      dialect,
 			DumpPath:     cli.DumpPath,
 			DumpDuration: cli.DumpDuration,
+			Log:          p.logger.Subsystem("dumper"),
 		}
 		err = p.dumper.Initialize()
 		if err != nil {
@@ -310,19 +1372,84 @@ func newProgram(args []string) (*program, error) {
 		}
 	}
 
-	if cli.Quiet {
-		log.SetOutput(io.Discard)
+	if cli.Tui {
+		p.tui = &tui.TUI{
+			Ctx:          ctx,
+			Wg:           &p.wg,
+			Writer:       os.Stdout,
+			Version:      version,
+			Traffic:      p.traffic,
+			MessageMan:   p.messageMan,
+			Registry:     p.registry,
+			ErrorMan:     p.errorMan,
+			Bonder:       p.bonder,
+			LogBuffer:    logBuffer,
+			LoopDetector: p.loopDetector,
+		}
+		err = p.tui.Initialize()
+		if err != nil {
+			ctxCancel()
+			p.wg.Wait()
+			p.node.Close()
+			return nil, err
+		}
 	}
 
-	log.Printf("mavp2p %s", version)
-	log.Printf("router started with %d %s",
-		len(endpointConfs),
-		func() string {
-			if len(endpointConfs) == 1 {
-				return "endpoint"
-			}
-			return "endpoints"
-		}())
+	p.routeStage = &pipeline.Stage{
+		Ctx:       ctx,
+		Wg:        &p.wg,
+		Name:      "route",
+		QueueSize: cli.PipelineQueueSize,
+		Process:   p.processRoute,
+		Log:       p.logger.Subsystem("pipeline"),
+	}
+	err = p.routeStage.Initialize()
+	if err != nil {
+		ctxCancel()
+		p.wg.Wait()
+		p.node.Close()
+		return nil, err
+	}
+
+	p.statsStage = &pipeline.Stage{
+		Ctx:       ctx,
+		Wg:        &p.wg,
+		Name:      "stats",
+		QueueSize: cli.PipelineQueueSize,
+		Lossy:     true,
+		Process:   p.processStats,
+		Log:       p.logger.Subsystem("pipeline"),
+	}
+	err = p.statsStage.Initialize()
+	if err != nil {
+		ctxCancel()
+		p.wg.Wait()
+		p.node.Close()
+		return nil, err
+	}
+
+	if p.printer != nil || p.dumper != nil {
+		p.exportStage = &pipeline.Stage{
+			Ctx:       ctx,
+			Wg:        &p.wg,
+			Name:      "export",
+			QueueSize: cli.PipelineQueueSize,
+			Lossy:     true,
+			Process:   p.processExport,
+			Log:       p.logger.Subsystem("pipeline"),
+		}
+		err = p.exportStage.Initialize()
+		if err != nil {
+			ctxCancel()
+			p.wg.Wait()
+			p.node.Close()
+			return nil, err
+		}
+	}
+
+	p.log.Info("router started",
+		slog.String("version", version),
+		slog.Int("endpoints", len(endpointConfs)))
 
 	p.wg.Add(1)
 	go p.run()
@@ -339,6 +1466,29 @@ func (p *program) wait() {
 	p.wg.Wait()
 }
 
+func (p *program) onRegistryEvent(evt registry.Event) {
+	if p.hooks == nil {
+		return
+	}
+
+	c := evt.Component
+
+	p.hooks.Fire(hooks.Event{
+		Type: hooks.EventComponentPrefix + evt.Type.String(),
+		Fields: map[string]string{
+			logger.FieldChannel:     c.Channel,
+			logger.FieldSystemID:    strconv.FormatUint(uint64(c.SystemID), 10),
+			logger.FieldComponentID: strconv.FormatUint(uint64(c.ComponentID), 10),
+			"type":                  c.Type.String(),
+			"autopilot":             c.Autopilot.String(),
+			"armed":                 strconv.FormatBool(c.Armed),
+			"base_mode":             strconv.FormatUint(uint64(c.BaseMode), 10),
+			"custom_mode":           strconv.FormatUint(uint64(c.CustomMode), 10),
+			"system_status":         c.SystemStatus.String(),
+		},
+	})
+}
+
 func (p *program) run() {
 	defer p.wg.Done()
 
@@ -349,32 +1499,199 @@ func (p *program) run() {
 		case e := <-p.node.Events():
 			switch evt := e.(type) {
 			case *gomavlib.EventChannelOpen:
-				log.Printf("channel opened: %s", evt.Channel)
+				p.log.Info("channel opened", logger.Channel(evt.Channel))
+				if p.hooks != nil {
+					p.hooks.Fire(hooks.Event{
+						Type:   hooks.EventChannelOpened,
+						Fields: map[string]string{logger.FieldChannel: evt.Channel.String()},
+					})
+				}
 
 			case *gomavlib.EventChannelClose:
-				log.Printf("channel closed: %s, %s", evt.Channel, evt.Error)
-				p.messageMan.ProcessChannelClose(evt)
+				p.log.Info("channel closed", logger.Channel(evt.Channel), slog.Any("error", evt.Error))
+				if p.hooks != nil {
+					p.hooks.Fire(hooks.Event{
+						Type: hooks.EventChannelClosed,
+						Fields: map[string]string{
+							logger.FieldChannel: evt.Channel.String(),
+							"error":             fmt.Sprint(evt.Error),
+						},
+					})
+				}
 
 			case *gomavlib.EventStreamRequested:
-				log.Printf("stream requested to chan=%s sid=%d cid=%d", evt.Channel,
-					evt.SystemID, evt.ComponentID)
+				p.log.Info("stream requested", logger.Channel(evt.Channel),
+					logger.SystemID(evt.SystemID), logger.ComponentID(evt.ComponentID))
+				continue
+			}
 
-			case *gomavlib.EventFrame:
-				if cli.Print {
-					log.Printf("%#v, %#v\n", evt.Frame, evt.Message())
-				}
-				p.messageMan.ProcessFrame(evt)
-				if p.dumper != nil {
-					p.dumper.ProcessFrame(evt)
-				}
+			// statistics are computed in a separate stage,
+			// in order not to delay routing.
+			// The stage discards frames when it is too slow, and counts them,
+			// so that it never holds up the push of the following frames to routing.
+			p.routeStage.Push(e)
+			p.statsStage.Push(e)
+
+		case <-p.ctx.Done():
+			return
+		}
+	}
+}
+
+func (p *program) processStats(e gomavlib.Event) {
+	switch evt := e.(type) {
+	case *gomavlib.EventChannelOpen:
+		p.traffic.ProcessChannelOpen(evt)
+
+	case *gomavlib.EventChannelClose:
+		p.linkStats.ProcessChannelClose(evt)
+		p.traffic.ProcessChannelClose(evt)
+
+	case *gomavlib.EventFrame:
+		p.linkStats.ProcessFrame(evt)
+		p.traffic.ProcessReceived(evt.Channel, evt.Frame)
+		p.errorMan.ProcessFrame(evt)
 
-			case *gomavlib.EventParseError:
-				p.errorMan.ProcessError(evt)
+	case *gomavlib.EventParseError:
+		p.errorMan.ProcessError(evt)
+	}
+}
+
+func (p *program) processRoute(e gomavlib.Event) {
+	switch evt := e.(type) {
+	case *gomavlib.EventChannelOpen:
+		// rejected clients are not routed
+		if p.acl != nil && p.acl.ProcessChannelOpen(evt) {
+			p.detached[evt.Channel] = struct{}{}
+			return
+		}
+
+		p.writer.ProcessChannelOpen(evt)
+		p.messageMan.ProcessChannelOpen(evt)
+		if p.shaper != nil {
+			p.shaper.ProcessChannelOpen(evt)
+		}
+		if p.heartbeat != nil {
+			p.heartbeat.ProcessChannelOpen(evt)
+		}
+		if p.bonder != nil {
+			p.bonder.ProcessChannelOpen(evt)
+		}
+
+	case *gomavlib.EventChannelClose:
+		if p.acl != nil {
+			p.acl.ProcessChannelClose(evt)
+		}
+
+		if _, ok := p.detached[evt.Channel]; ok {
+			delete(p.detached, evt.Channel)
+			return
+		}
+
+		p.closeChannel(evt)
+
+	case *gomavlib.EventFrame:
+		if p.acl != nil {
+			switch p.acl.ProcessFrame(evt) {
+			case acl.VerdictDiscard:
+				return
+
+			case acl.VerdictReject:
+				p.detach(evt.Channel)
+				return
 			}
+		}
 
-		case <-p.ctx.Done():
+		if _, ok := p.detached[evt.Channel]; ok {
 			return
 		}
+
+		p.processFrame(evt)
+	}
+
+	// channels that are too slow are removed from routing until they are closed.
+	for _, ch := range p.writer.Disconnected() {
+		p.detach(ch)
+	}
+}
+
+// detach removes a channel from routing until it is closed.
+func (p *program) detach(ch *gomavlib.Channel) {
+	p.detached[ch] = struct{}{}
+	p.closeChannel(&gomavlib.EventChannelClose{Channel: ch})
+}
+
+// closeChannel removes a channel from routing.
+func (p *program) closeChannel(evt *gomavlib.EventChannelClose) {
+	p.registry.ProcessChannelClose(evt)
+	p.messageMan.ProcessChannelClose(evt)
+	if p.crcVerifier != nil {
+		p.crcVerifier.ProcessChannelClose(evt)
+	}
+	if p.bonder != nil {
+		p.bonder.ProcessChannelClose(evt)
+	}
+	if p.decimator != nil {
+		p.decimator.ProcessChannelClose(evt)
+	}
+	if p.shaper != nil {
+		p.shaper.ProcessChannelClose(evt)
+	}
+	p.writer.ProcessChannelClose(evt)
+	if p.converter != nil {
+		p.converter.ProcessChannelClose(evt)
+	}
+	if p.heartbeat != nil {
+		p.heartbeat.ProcessChannelClose(evt)
+	}
+	if p.streamConf != nil {
+		p.streamConf.ProcessChannelClose(evt)
+	}
+}
+
+func (p *program) processFrame(evt *gomavlib.EventFrame) {
+	if p.crcVerifier != nil && p.crcVerifier.ProcessFrame(evt) {
+		return
+	}
+
+	if p.shaper != nil {
+		p.shaper.ProcessFrame(evt)
+	}
+
+	if p.bonder != nil && p.bonder.ProcessFrame(evt) {
+		return
+	}
+
+	if p.loopDetector != nil && p.loopDetector.ProcessFrame(evt) {
+		return
+	}
+
+	p.registry.ProcessFrame(evt)
+	if p.heartbeat != nil {
+		p.heartbeat.ProcessFrame(evt)
+	}
+
+	if p.streamConf != nil && p.streamConf.ProcessFrame(evt) {
+		return
+	}
+
+	p.messageMan.ProcessFrame(evt)
+
+	// frames are printed and dumped in a separate stage,
+	// in order not to delay routing.
+	if p.exportStage != nil {
+		p.exportStage.Push(evt)
+	}
+}
+
+func (p *program) processExport(e gomavlib.Event) {
+	evt := e.(*gomavlib.EventFrame)
+
+	if p.printer != nil {
+		p.printer.ProcessFrame(evt)
+	}
+	if p.dumper != nil {
+		p.dumper.ProcessFrame(evt)
 	}
 }
 
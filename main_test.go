@@ -1,12 +1,21 @@
 package main
 
 import (
+	"net/netip"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/bluenviron/gomavlib/v4"
 	"github.com/bluenviron/gomavlib/v4/pkg/dialects/common"
 	"github.com/stretchr/testify/require"
+
+	"github.com/bluenviron/mavp2p/pkg/acl"
+	"github.com/bluenviron/mavp2p/pkg/converter"
+	"github.com/bluenviron/mavp2p/pkg/decimator"
+	"github.com/bluenviron/mavp2p/pkg/messageman"
+	"github.com/bluenviron/mavp2p/pkg/shaper"
+	"github.com/bluenviron/mavp2p/pkg/streamconf"
 )
 
 func TestBroadcast(t *testing.T) {
@@ -248,3 +257,240 @@ func TestTargetNotFound(t *testing.T) {
 	require.Equal(t, true, ok)
 	require.Equal(t, msg, eventFr.Frame.GetMessage())
 }
+
+// BenchmarkRouting measures the frames delivered by the router
+// when a publisher sends 20k frames/s to 49 subscribers.
+// It fails when frames are lost.
+func BenchmarkRouting(b *testing.B) {
+	const (
+		subscriberCount = 49
+		frameRate       = 20000
+		framesPerTick   = frameRate / 1000
+	)
+
+	p, err := newProgram([]string{"tcps:127.0.0.1:6667"})
+	require.NoError(b, err)
+	defer p.close()
+
+	newClient := func(systemID byte) *gomavlib.Node {
+		n := &gomavlib.Node{
+			Endpoints: []gomavlib.Endpoint{
+				&gomavlib.EndpointTCPClient{
+					Address: "127.0.0.1:6667",
+				},
+			},
+			OutVersion:       gomavlib.V2,
+			OutSystemID:      systemID,
+			OutComponentID:   1,
+			Dialect:          common.Dialect,
+			HeartbeatDisable: true,
+		}
+		err = n.Initialize()
+		require.NoError(b, err)
+		<-n.Events()
+		return n
+	}
+
+	pub := newClient(1)
+	defer pub.Close()
+
+	var received atomic.Uint64
+
+	subs := make([]*gomavlib.Node, subscriberCount)
+	defer func() {
+		for _, sub := range subs {
+			if sub != nil {
+				sub.Close()
+			}
+		}
+	}()
+
+	for i := range subs {
+		sub := newClient(byte(10 + i))
+		subs[i] = sub
+
+		go func() {
+			for evt := range sub.Events() {
+				if fr, ok := evt.(*gomavlib.EventFrame); ok {
+					if _, ok = fr.Message().(*common.MessageAttitude); ok {
+						received.Add(1)
+					}
+				}
+			}
+		}()
+	}
+
+	// wait for the router to process channel events
+	time.Sleep(500 * time.Millisecond)
+
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+
+	start := time.Now()
+	b.ResetTimer()
+
+	for sent := 0; sent < b.N; {
+		<-ticker.C
+		for i := 0; i < framesPerTick && sent < b.N; i++ {
+			err = pub.WriteMessageAll(&common.MessageAttitude{TimeBootMs: uint32(sent)})
+			require.NoError(b, err)
+			sent++
+		}
+	}
+
+	expected := uint64(b.N) * subscriberCount
+	deadline := time.Now().Add(5 * time.Second)
+	for received.Load() < expected && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	b.StopTimer()
+
+	b.ReportMetric(float64(received.Load())/time.Since(start).Seconds(), "delivered/s")
+	b.ReportMetric(1-float64(received.Load())/float64(expected), "loss")
+
+	if received.Load() != expected {
+		b.Errorf("%d frames delivered, %d expected", received.Load(), expected)
+	}
+}
+
+func TestGenerateStaticRoutes(t *testing.T) {
+	radio := &gomavlib.EndpointSerial{Device: "/dev/ttyAMA0", Baud: 57600}
+	names := map[string]gomavlib.Endpoint{
+		"radio":                     radio,
+		"serial:/dev/ttyAMA0:57600": radio,
+	}
+
+	routes, err := generateStaticRoutes([]string{"1=radio", "255/190=serial:/dev/ttyAMA0:57600"}, names)
+	require.NoError(t, err)
+	require.Equal(t, []messageman.StaticRoute{
+		{SystemID: 1, Endpoint: radio},
+		{SystemID: 255, ComponentID: 190, Endpoint: radio},
+	}, routes)
+
+	for _, ca := range []string{"radio", "0=radio", "1/x=radio", "1=other"} {
+		_, err = generateStaticRoutes([]string{ca}, names)
+		require.Error(t, err)
+	}
+}
+
+func TestGenerateStreamIntervals(t *testing.T) {
+	intervals, err := generateStreamIntervals([]string{"ATTITUDE=20", "system_time=0.5", "12915=0"})
+	require.NoError(t, err)
+	require.Equal(t, []streamconf.Interval{
+		{MessageID: 30, Name: "ATTITUDE", Rate: 20},
+		{MessageID: 2, Name: "SYSTEM_TIME", Rate: 0.5},
+		{MessageID: 12915, Name: "12915", Rate: 0},
+	}, intervals)
+
+	for _, ca := range []string{"ATTITUDE", "ATTITUDE=x", "ATTITUDE=-1", "NOT_A_MESSAGE=1"} {
+		_, err = generateStreamIntervals([]string{ca})
+		require.Error(t, err)
+	}
+}
+
+func TestGenerateDecimationLimits(t *testing.T) {
+	relay := &gomavlib.EndpointUDPClient{Address: "10.0.0.2:14550"}
+	names := map[string]gomavlib.Endpoint{
+		"relay":               relay,
+		"udpc:10.0.0.2:14550": relay,
+	}
+
+	limits, err := generateDecimationLimits([]string{"relay=ATTITUDE=4", "udpc:10.0.0.2:14550=heartbeat=0.5"}, names)
+	require.NoError(t, err)
+	require.Equal(t, []decimator.Limit{
+		{Endpoint: relay, MessageID: 30, Rate: 4},
+		{Endpoint: relay, MessageID: 0, Rate: 0.5},
+	}, limits)
+
+	for _, ca := range []string{"relay", "relay=4", "relay=ATTITUDE=0", "relay=NOT_A_MESSAGE=1", "other=ATTITUDE=4"} {
+		_, err = generateDecimationLimits([]string{ca}, names)
+		require.Error(t, err)
+	}
+}
+
+func TestGenerateShapingBudgets(t *testing.T) {
+	radio := &gomavlib.EndpointSerial{Device: "/dev/ttyUSB0", Baud: 57600}
+	names := map[string]gomavlib.Endpoint{
+		"radio":                     radio,
+		"serial:/dev/ttyUSB0:57600": radio,
+	}
+
+	budgets, err := generateShapingBudgets([]string{"radio=5000"}, names)
+	require.NoError(t, err)
+	require.Equal(t, []shaper.Budget{{Endpoint: radio, Rate: 5000}}, budgets)
+
+	for _, ca := range []string{"radio", "radio=0", "radio=x", "other=5000"} {
+		_, err = generateShapingBudgets([]string{ca}, names)
+		require.Error(t, err)
+	}
+}
+
+func TestGenerateOutVersions(t *testing.T) {
+	tracker := &gomavlib.EndpointSerial{Device: "/dev/ttyUSB0", Baud: 57600}
+	gcs := &gomavlib.EndpointUDPServer{Address: ":14550"}
+	names := map[string]gomavlib.Endpoint{
+		"tracker":            tracker,
+		"udps:0.0.0.0:14550": gcs,
+	}
+
+	versions, err := generateOutVersions([]string{"tracker=1", "udps:0.0.0.0:14550=2"}, names)
+	require.NoError(t, err)
+	require.Equal(t, []converter.OutVersion{
+		{Endpoint: tracker, Version: gomavlib.V1},
+		{Endpoint: gcs, Version: gomavlib.V2},
+	}, versions)
+
+	for _, ca := range []string{"tracker", "tracker=3", "tracker=v1", "other=1"} {
+		_, err = generateOutVersions([]string{ca}, names)
+		require.Error(t, err)
+	}
+}
+
+func TestGenerateAccessRules(t *testing.T) {
+	gcs := &gomavlib.EndpointUDPServer{Address: ":14550"}
+	tcps := &gomavlib.EndpointTCPServer{Address: ":5600"}
+	radio := &gomavlib.EndpointSerial{Device: "/dev/ttyUSB0", Baud: 57600}
+	names := map[string]gomavlib.Endpoint{
+		"gcs":               gcs,
+		"tcps:0.0.0.0:5600": tcps,
+		"radio":             radio,
+	}
+
+	rules, err := generateAccessRules(
+		[]string{"gcs=10.0.0.0/8,192.168.1.5"},
+		[]string{"gcs=10.0.0.66", "tcps:0.0.0.0:5600=fd00::/8"},
+		[]string{"tcps:0.0.0.0:5600=4"},
+		[]string{"gcs"},
+		names)
+	require.NoError(t, err)
+	require.Equal(t, []acl.Rule{
+		{
+			Endpoint: gcs,
+			Allow: []netip.Prefix{
+				netip.MustParsePrefix("10.0.0.0/8"),
+				netip.MustParsePrefix("192.168.1.5/32"),
+			},
+			Deny:            []netip.Prefix{netip.MustParsePrefix("10.0.0.66/32")},
+			UniqueSystemIDs: true,
+		},
+		{
+			Endpoint:   tcps,
+			Deny:       []netip.Prefix{netip.MustParsePrefix("fd00::/8")},
+			MaxClients: 4,
+		},
+	}, rules)
+
+	for _, ca := range []string{"gcs", "gcs=10.0.0.0/33", "gcs=x", "radio=10.0.0.0/8", "other=10.0.0.0/8"} {
+		_, err = generateAccessRules([]string{ca}, nil, nil, nil, names)
+		require.Error(t, err)
+	}
+
+	for _, ca := range []string{"gcs", "gcs=0", "gcs=x", "radio=4"} {
+		_, err = generateAccessRules(nil, nil, []string{ca}, nil, names)
+		require.Error(t, err)
+	}
+
+	_, err = generateAccessRules(nil, nil, nil, []string{"radio"}, names)
+	require.Error(t, err)
+}
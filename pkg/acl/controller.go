@@ -0,0 +1,304 @@
+// Package acl contains the access controller of server endpoints.
+package acl
+
+import (
+	"context"
+	"log/slog"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gomavlib/v4"
+
+	"github.com/bluenviron/mavp2p/pkg/logger"
+)
+
+const reportPeriod = 10 * time.Second
+
+var (
+	channelEndpoint = func(ch *gomavlib.Channel) gomavlib.Endpoint { return ch.Endpoint() }
+	channelLabel    = func(ch *gomavlib.Channel) string { return ch.String() }
+)
+
+// Rule is a set of access rules of a server endpoint.
+type Rule struct {
+	Endpoint gomavlib.Endpoint
+
+	// when not empty, only clients whose address is in these networks are accepted.
+	Allow []netip.Prefix
+
+	// clients whose address is in these networks are rejected.
+	Deny []netip.Prefix
+
+	// maximum number of clients. Zero means no limit.
+	MaxClients int
+
+	// reject clients that send frames with system IDs that are bound to another client.
+	UniqueSystemIDs bool
+}
+
+// Verdict is the outcome of the check of a frame.
+type Verdict int
+
+// verdicts.
+const (
+	// VerdictAccept means that the frame can be routed.
+	VerdictAccept Verdict = iota
+
+	// VerdictDiscard means that the frame comes from a rejected client.
+	VerdictDiscard
+
+	// VerdictReject means that the frame caused the rejection of its client.
+	VerdictReject
+)
+
+// EndpointStats are the access statistics of an endpoint.
+type EndpointStats struct {
+	Endpoint        gomavlib.Endpoint
+	Clients         int
+	DeniedAddresses uint64
+	TooManyClients  uint64
+	SystemConflicts uint64
+}
+
+type endpointState struct {
+	rule     *Rule
+	clients  int
+	systems  map[byte]*gomavlib.Channel
+	denied   uint64
+	tooMany  uint64
+	conflict uint64
+}
+
+type client struct {
+	endpoint  *endpointState
+	rejected  bool
+	discarded uint64
+	reported  uint64
+}
+
+// channelAddr returns the remote address of a client, that is contained in the label of its channel,
+// in the tcp:address:port or udp:address:port format.
+func channelAddr(ch *gomavlib.Channel) (netip.Addr, bool) {
+	label := channelLabel(ch)
+
+	ap, err := netip.ParseAddrPort(label)
+	if err != nil {
+		_, rest, ok := strings.Cut(label, ":")
+		if !ok {
+			return netip.Addr{}, false
+		}
+
+		ap, err = netip.ParseAddrPort(rest)
+		if err != nil {
+			return netip.Addr{}, false
+		}
+	}
+
+	return ap.Addr().Unmap(), true
+}
+
+func contains(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Controller accepts or rejects clients of server endpoints.
+// Since channels cannot be closed individually, rejected clients are detached from routing:
+// their frames are discarded and nothing is sent to them.
+// Their connections stay open until clients disconnect or become idle.
+type Controller struct {
+	Ctx   context.Context
+	Wg    *sync.WaitGroup
+	Rules []Rule
+	Log   *slog.Logger
+
+	mutex     sync.Mutex
+	endpoints map[gomavlib.Endpoint]*endpointState
+	clients   map[*gomavlib.Channel]*client
+}
+
+// Initialize initializes a Controller.
+func (c *Controller) Initialize() error {
+	if c.Log == nil {
+		c.Log = slog.Default()
+	}
+
+	c.endpoints = make(map[gomavlib.Endpoint]*endpointState)
+	for i := range c.Rules {
+		c.endpoints[c.Rules[i].Endpoint] = &endpointState{
+			rule:    &c.Rules[i],
+			systems: make(map[byte]*gomavlib.Channel),
+		}
+	}
+
+	c.clients = make(map[*gomavlib.Channel]*client)
+
+	c.Wg.Add(1)
+	go c.run()
+
+	return nil
+}
+
+func (c *Controller) run() {
+	defer c.Wg.Done()
+
+	ticker := time.NewTicker(reportPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.report()
+
+		case <-c.Ctx.Done():
+			return
+		}
+	}
+}
+
+// report logs frames of rejected clients that have been discarded since the last report.
+func (c *Controller) report() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for ch, cl := range c.clients {
+		if n := cl.discarded - cl.reported; n != 0 {
+			c.Log.Warn("frames of rejected client discarded", logger.Channel(ch), slog.Uint64("count", n))
+		}
+		cl.reported = cl.discarded
+	}
+}
+
+// release unbinds a client from its endpoint.
+func (es *endpointState) release(ch *gomavlib.Channel) {
+	es.clients--
+
+	for id, owner := range es.systems {
+		if owner == ch {
+			delete(es.systems, id)
+		}
+	}
+}
+
+func (c *Controller) reject(ch *gomavlib.Channel, cl *client, reason string, attrs ...any) {
+	cl.rejected = true
+	cl.endpoint.release(ch)
+
+	c.Log.Warn("client rejected", append([]any{logger.Channel(ch), slog.String("reason", reason)}, attrs...)...)
+}
+
+// ProcessChannelOpen processes a EventChannelOpen.
+// It returns true if the client is rejected and must not be routed.
+func (c *Controller) ProcessChannelOpen(evt *gomavlib.EventChannelOpen) bool {
+	es, ok := c.endpoints[channelEndpoint(evt.Channel)]
+	if !ok {
+		return false
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	cl := &client{endpoint: es}
+	c.clients[evt.Channel] = cl
+	es.clients++
+
+	if len(es.rule.Allow) != 0 || len(es.rule.Deny) != 0 {
+		addr, ok := channelAddr(evt.Channel)
+		if !ok || (len(es.rule.Allow) != 0 && !contains(es.rule.Allow, addr)) || contains(es.rule.Deny, addr) {
+			es.denied++
+			c.reject(evt.Channel, cl, "address is not allowed")
+			return true
+		}
+	}
+
+	if es.rule.MaxClients != 0 && es.clients > es.rule.MaxClients {
+		es.tooMany++
+		c.reject(evt.Channel, cl, "too many clients", slog.Int("max", es.rule.MaxClients))
+		return true
+	}
+
+	return false
+}
+
+// ProcessChannelClose processes a EventChannelClose.
+func (c *Controller) ProcessChannelClose(evt *gomavlib.EventChannelClose) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	cl, ok := c.clients[evt.Channel]
+	if !ok {
+		return
+	}
+
+	delete(c.clients, evt.Channel)
+
+	if !cl.rejected {
+		cl.endpoint.release(evt.Channel)
+	}
+}
+
+// ProcessFrame processes a EventFrame.
+func (c *Controller) ProcessFrame(evt *gomavlib.EventFrame) Verdict {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	cl, ok := c.clients[evt.Channel]
+	if !ok {
+		return VerdictAccept
+	}
+
+	if cl.rejected {
+		cl.discarded++
+		return VerdictDiscard
+	}
+
+	if !cl.endpoint.rule.UniqueSystemIDs {
+		return VerdictAccept
+	}
+
+	id := evt.SystemID()
+
+	owner, ok := cl.endpoint.systems[id]
+	if !ok {
+		cl.endpoint.systems[id] = evt.Channel
+		return VerdictAccept
+	}
+
+	if owner == evt.Channel {
+		return VerdictAccept
+	}
+
+	cl.endpoint.conflict++
+	c.reject(evt.Channel, cl, "system ID is bound to another client",
+		logger.SystemID(id), slog.String("owner", channelLabel(owner)))
+	cl.discarded++
+
+	return VerdictReject
+}
+
+// Stats returns the access statistics of endpoints, in the order of rules.
+func (c *Controller) Stats() []EndpointStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	ret := make([]EndpointStats, 0, len(c.Rules))
+
+	for _, r := range c.Rules {
+		es := c.endpoints[r.Endpoint]
+		ret = append(ret, EndpointStats{
+			Endpoint:        r.Endpoint,
+			Clients:         es.clients,
+			DeniedAddresses: es.denied,
+			TooManyClients:  es.tooMany,
+			SystemConflicts: es.conflict,
+		})
+	}
+
+	return ret
+}
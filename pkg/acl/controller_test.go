@@ -0,0 +1,126 @@
+package acl
+
+import (
+	"context"
+	"net/netip"
+	"sync"
+	"testing"
+
+	"github.com/bluenviron/gomavlib/v4"
+	"github.com/bluenviron/gomavlib/v4/pkg/frame"
+	"github.com/stretchr/testify/require"
+)
+
+func TestController(t *testing.T) {
+	tcps := &gomavlib.EndpointTCPServer{Address: ":5600"}
+	udps := &gomavlib.EndpointUDPServer{Address: ":14550"}
+	serial := &gomavlib.EndpointSerial{}
+
+	type testChannel struct {
+		endpoint gomavlib.Endpoint
+		label    string
+	}
+	channels := make(map[*gomavlib.Channel]testChannel)
+
+	newChannel := func(e gomavlib.Endpoint, label string) *gomavlib.Channel {
+		ch := &gomavlib.Channel{}
+		channels[ch] = testChannel{e, label}
+		return ch
+	}
+
+	channelEndpoint = func(ch *gomavlib.Channel) gomavlib.Endpoint { return channels[ch].endpoint }
+	channelLabel = func(ch *gomavlib.Channel) string { return channels[ch].label }
+	defer func() {
+		channelEndpoint = func(ch *gomavlib.Channel) gomavlib.Endpoint { return ch.Endpoint() }
+		channelLabel = func(ch *gomavlib.Channel) string { return ch.String() }
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	c := &Controller{
+		Ctx: ctx,
+		Wg:  &wg,
+		Rules: []Rule{
+			{
+				Endpoint:   tcps,
+				Allow:      []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+				Deny:       []netip.Prefix{netip.MustParsePrefix("10.0.0.66/32")},
+				MaxClients: 2,
+			},
+			{
+				Endpoint:        udps,
+				UniqueSystemIDs: true,
+			},
+		},
+	}
+	err := c.Initialize()
+	require.NoError(t, err)
+
+	open := func(ch *gomavlib.Channel) bool {
+		return c.ProcessChannelOpen(&gomavlib.EventChannelOpen{Channel: ch})
+	}
+
+	sendFrame := func(ch *gomavlib.Channel, systemID byte) Verdict {
+		return c.ProcessFrame(&gomavlib.EventFrame{
+			Frame:   &frame.V2Frame{SystemID: systemID, ComponentID: 1},
+			Channel: ch,
+		})
+	}
+
+	// channels of endpoints without rules are always accepted
+	serialCh := newChannel(serial, "serial:/dev/ttyUSB0")
+	require.False(t, open(serialCh))
+	require.Equal(t, VerdictAccept, sendFrame(serialCh, 1))
+
+	// address lists
+	require.True(t, open(newChannel(tcps, "tcp:192.168.1.2:40000")))
+	require.True(t, open(newChannel(tcps, "tcp:10.0.0.66:40000")))
+	require.True(t, open(newChannel(tcps, "invalid")))
+	first := newChannel(tcps, "tcp:10.0.0.1:40000")
+	require.False(t, open(first))
+	require.False(t, open(newChannel(tcps, "tcp:[::ffff:10.0.0.2]:40000")))
+
+	// maximum number of clients
+	third := newChannel(tcps, "tcp:10.0.0.3:40000")
+	require.True(t, open(third))
+	require.Equal(t, VerdictDiscard, sendFrame(third, 1))
+
+	c.ProcessChannelClose(&gomavlib.EventChannelClose{Channel: first})
+	require.False(t, open(newChannel(tcps, "tcp:10.0.0.4:40000")))
+
+	// system IDs bound to another client
+	gcs1 := newChannel(udps, "udp:192.168.1.2:14550")
+	gcs2 := newChannel(udps, "udp:192.168.1.3:14550")
+	require.False(t, open(gcs1))
+	require.False(t, open(gcs2))
+	require.Equal(t, VerdictAccept, sendFrame(gcs1, 255))
+	require.Equal(t, VerdictAccept, sendFrame(gcs1, 255))
+	require.Equal(t, VerdictAccept, sendFrame(gcs2, 254))
+	require.Equal(t, VerdictReject, sendFrame(gcs2, 255))
+	require.Equal(t, VerdictDiscard, sendFrame(gcs2, 254))
+
+	// system IDs are released when clients disconnect
+	c.ProcessChannelClose(&gomavlib.EventChannelClose{Channel: gcs1})
+	gcs3 := newChannel(udps, "udp:192.168.1.4:14550")
+	require.False(t, open(gcs3))
+	require.Equal(t, VerdictAccept, sendFrame(gcs3, 255))
+	require.Equal(t, VerdictAccept, sendFrame(gcs3, 254))
+
+	require.Equal(t, []EndpointStats{
+		{
+			Endpoint:        tcps,
+			Clients:         2,
+			DeniedAddresses: 3,
+			TooManyClients:  1,
+		},
+		{
+			Endpoint:        udps,
+			Clients:         1,
+			SystemConflicts: 1,
+		},
+	}, c.Stats())
+
+	cancel()
+	wg.Wait()
+}
@@ -0,0 +1,476 @@
+// Package bonder contains the link bonder.
+package bonder
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gomavlib/v4"
+
+	"github.com/bluenviron/mavp2p/pkg/hooks"
+	"github.com/bluenviron/mavp2p/pkg/linkstats"
+	"github.com/bluenviron/mavp2p/pkg/logger"
+)
+
+const (
+	healthInterval = 1 * time.Second
+	dedupWindow    = 2 * time.Second
+	lossSmoothing  = 0.3
+	unhealthyLoss  = 0.5
+	maxSwitches    = 16
+	heartbeatID    = 0
+)
+
+var (
+	timeNow         = time.Now
+	channelEndpoint = func(ch *gomavlib.Channel) gomavlib.Endpoint { return ch.Endpoint() }
+)
+
+// Policy is the policy used to route outbound frames to a group.
+type Policy int
+
+// policies.
+const (
+	// PolicyAll routes outbound frames to all healthy members.
+	PolicyAll Policy = iota
+
+	// PolicyBest routes outbound frames to the healthy member with the lowest packet loss.
+	PolicyBest
+
+	// PolicyFailover routes outbound frames to the healthy member with the highest priority.
+	// Members with higher priority are restored after being healthy for the hysteresis period.
+	PolicyFailover
+)
+
+type sourceKey struct {
+	systemID    byte
+	componentID byte
+}
+
+type sequenceSlot struct {
+	channel   *gomavlib.Channel
+	messageID uint32
+	checksum  uint16
+	time      time.Time
+}
+
+type member struct {
+	group         *group
+	channel       *gomavlib.Channel
+	priority      int
+	lastHeartbeat time.Time
+	lastReceived  uint64
+	lastLost      uint64
+	loss          float64
+	healthy       bool
+	healthySince  time.Time
+}
+
+// processCounters updates the packet loss with the counters of the link statistics tracker.
+func (m *member) processCounters(received uint64, lost uint64) {
+	newReceived := received - m.lastReceived
+
+	// lost frames decrease when they are received out of order
+	var newLost uint64
+	if lost > m.lastLost {
+		newLost = lost - m.lastLost
+	}
+
+	m.lastReceived = received
+	m.lastLost = lost
+
+	if total := newReceived + newLost; total != 0 {
+		m.loss = m.loss*(1-lossSmoothing) + float64(newLost)/float64(total)*lossSmoothing
+	}
+}
+
+type group struct {
+	members   []*member
+	sequences map[sourceKey]*[256]sequenceSlot
+	active    *member
+	switches  []SwitchEvent
+}
+
+type endpointPosition struct {
+	group    *group
+	priority int
+}
+
+// MemberStatus is the status of a group member.
+type MemberStatus struct {
+	Channel string
+	Healthy bool
+	Loss    float64
+}
+
+// SwitchEvent is a change of the active member of a group.
+type SwitchEvent struct {
+	Time time.Time
+	From string
+	To   string
+}
+
+// GroupStatus is the status of a group.
+type GroupStatus struct {
+	Members  []MemberStatus
+	Active   string
+	Switches []SwitchEvent
+}
+
+// Bonder is a link bonder.
+// It merges endpoints that are redundant paths to the same vehicle:
+// inbound frames are deduplicated and outbound frames are routed
+// to members according to their health.
+type Bonder struct {
+	Ctx              context.Context
+	Wg               *sync.WaitGroup
+	Groups           [][]gomavlib.Endpoint
+	Policy           Policy
+	HeartbeatTimeout time.Duration
+	Hysteresis       time.Duration
+
+	// used to compute packet loss of members.
+	LinkStats *linkstats.Tracker
+
+	// used to notify switches of the active member. It can be nil.
+	Hooks *hooks.Hooks
+
+	Log *slog.Logger
+
+	mutex          sync.Mutex
+	groups         []*group
+	endpoints      map[gomavlib.Endpoint]endpointPosition
+	members        map[*gomavlib.Channel]*member
+	duplicateCount uint64
+}
+
+// Initialize initializes a Bonder.
+func (b *Bonder) Initialize() error {
+	if b.Log == nil {
+		b.Log = slog.Default()
+	}
+
+	b.endpoints = make(map[gomavlib.Endpoint]endpointPosition)
+	b.members = make(map[*gomavlib.Channel]*member)
+
+	for _, endpoints := range b.Groups {
+		g := &group{
+			sequences: make(map[sourceKey]*[256]sequenceSlot),
+		}
+		b.groups = append(b.groups, g)
+
+		for i, e := range endpoints {
+			b.endpoints[e] = endpointPosition{
+				group:    g,
+				priority: i,
+			}
+		}
+	}
+
+	b.Wg.Add(1)
+	go b.run()
+
+	return nil
+}
+
+func (b *Bonder) run() {
+	defer b.Wg.Done()
+
+	t := time.NewTicker(healthInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			b.updateHealth()
+
+		case <-b.Ctx.Done():
+			return
+		}
+	}
+}
+
+func (b *Bonder) updateHealth() {
+	now := timeNow()
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for _, g := range b.groups {
+		for _, m := range g.members {
+			m.processCounters(b.LinkStats.ChannelCounters(m.channel))
+
+			healthy := !m.lastHeartbeat.IsZero() &&
+				now.Sub(m.lastHeartbeat) < b.HeartbeatTimeout &&
+				m.loss < unhealthyLoss
+
+			if healthy != m.healthy {
+				m.healthy = healthy
+				if healthy {
+					m.healthySince = now
+					b.Log.Info("bond member is healthy", logger.Channel(m.channel))
+				} else {
+					b.Log.Warn("bond member is unhealthy", logger.Channel(m.channel), slog.Float64("loss", m.loss))
+				}
+			}
+		}
+
+		if b.Policy == PolicyFailover {
+			b.updateActive(g, now)
+		}
+	}
+}
+
+func (b *Bonder) updateActive(g *group, now time.Time) {
+	var next *member
+
+	for _, m := range g.members {
+		if !m.healthy {
+			continue
+		}
+
+		// hysteresis
+		if g.active != nil && g.active.healthy &&
+			m.priority < g.active.priority && now.Sub(m.healthySince) < b.Hysteresis {
+			continue
+		}
+
+		next = m
+		break
+	}
+
+	if next == nil || next == g.active {
+		return
+	}
+
+	evt := SwitchEvent{
+		Time: now,
+		To:   next.channel.String(),
+	}
+	if g.active != nil {
+		evt.From = g.active.channel.String()
+	}
+	b.Log.Info("bond switched", logger.Channel(next.channel), slog.String("previous_channel", evt.From))
+
+	if b.Hooks != nil {
+		b.Hooks.Fire(hooks.Event{
+			Type: hooks.EventBondSwitched,
+			Fields: map[string]string{
+				logger.FieldChannel: evt.To,
+				"previous_channel":  evt.From,
+			},
+		})
+	}
+
+	g.active = next
+	g.switches = append(g.switches, evt)
+	if len(g.switches) > maxSwitches {
+		g.switches = g.switches[1:]
+	}
+}
+
+func (b *Bonder) selectMembers(g *group) []*gomavlib.Channel {
+	var candidates []*member
+	for _, m := range g.members {
+		if m.healthy {
+			candidates = append(candidates, m)
+		}
+	}
+
+	if b.Policy == PolicyFailover {
+		switch {
+		case g.active != nil:
+			return []*gomavlib.Channel{g.active.channel}
+
+		case len(candidates) != 0:
+			return []*gomavlib.Channel{candidates[0].channel}
+
+		case len(g.members) != 0:
+			return []*gomavlib.Channel{g.members[0].channel}
+
+		default:
+			return nil
+		}
+	}
+
+	// when health is unknown, use all members
+	if len(candidates) == 0 {
+		candidates = g.members
+		if len(candidates) == 0 {
+			return nil
+		}
+	}
+
+	if b.Policy == PolicyBest {
+		best := candidates[0]
+		for _, m := range candidates[1:] {
+			if m.loss < best.loss {
+				best = m
+			}
+		}
+		return []*gomavlib.Channel{best.channel}
+	}
+
+	ret := make([]*gomavlib.Channel, len(candidates))
+	for i, m := range candidates {
+		ret[i] = m.channel
+	}
+	return ret
+}
+
+// ProcessChannelOpen processes a EventChannelOpen.
+func (b *Bonder) ProcessChannelOpen(evt *gomavlib.EventChannelOpen) {
+	pos, ok := b.endpoints[channelEndpoint(evt.Channel)]
+	if !ok {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	m := &member{
+		group:    pos.group,
+		channel:  evt.Channel,
+		priority: pos.priority,
+	}
+	b.members[evt.Channel] = m
+
+	pos.group.members = append(pos.group.members, m)
+	slices.SortStableFunc(pos.group.members, func(x, y *member) int {
+		return x.priority - y.priority
+	})
+}
+
+// ProcessChannelClose processes a EventChannelClose.
+func (b *Bonder) ProcessChannelClose(evt *gomavlib.EventChannelClose) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	m, ok := b.members[evt.Channel]
+	if !ok {
+		return
+	}
+
+	delete(b.members, evt.Channel)
+	if m.group.active == m {
+		m.group.active = nil
+	}
+	m.group.members = slices.DeleteFunc(m.group.members, func(o *member) bool {
+		return o == m
+	})
+}
+
+// ProcessFrame processes a EventFrame.
+// It returns true when the frame was already received from another member of the group
+// and must be discarded.
+func (b *Bonder) ProcessFrame(evt *gomavlib.EventFrame) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	m, ok := b.members[evt.Channel]
+	if !ok {
+		return false
+	}
+
+	key := sourceKey{
+		systemID:    evt.SystemID(),
+		componentID: evt.ComponentID(),
+	}
+	seq := evt.Frame.GetSequenceNumber()
+	messageID := evt.Message().GetID()
+	checksum := evt.Frame.GetChecksum()
+	now := timeNow()
+
+	if messageID == heartbeatID {
+		m.lastHeartbeat = now
+	}
+
+	sequences, ok := m.group.sequences[key]
+	if !ok {
+		sequences = &[256]sequenceSlot{}
+		m.group.sequences[key] = sequences
+	}
+
+	slot := &sequences[seq]
+	if slot.channel != nil && slot.channel != evt.Channel &&
+		slot.messageID == messageID && slot.checksum == checksum &&
+		now.Sub(slot.time) < dedupWindow {
+		b.duplicateCount++
+		return true
+	}
+
+	*slot = sequenceSlot{
+		channel:   evt.Channel,
+		messageID: messageID,
+		checksum:  checksum,
+		time:      now,
+	}
+	return false
+}
+
+// Egress returns the channels where a frame, received from source and routed to targets,
+// has to be written.
+// Members of a group are replaced with the members selected by the policy,
+// while members of the group of source are removed.
+func (b *Bonder) Egress(source *gomavlib.Channel, targets []*gomavlib.Channel) []*gomavlib.Channel {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var sourceGroup *group
+	if m, ok := b.members[source]; ok {
+		sourceGroup = m.group
+	}
+
+	ret := make([]*gomavlib.Channel, 0, len(targets))
+	var done []*group
+
+	for _, ch := range targets {
+		m, ok := b.members[ch]
+		if !ok {
+			ret = append(ret, ch)
+			continue
+		}
+
+		if m.group == sourceGroup || slices.Contains(done, m.group) {
+			continue
+		}
+		done = append(done, m.group)
+
+		ret = append(ret, b.selectMembers(m.group)...)
+	}
+
+	return ret
+}
+
+// Status returns the status of groups.
+func (b *Bonder) Status() []GroupStatus {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	ret := make([]GroupStatus, len(b.groups))
+	for i, g := range b.groups {
+		if g.active != nil {
+			ret[i].Active = g.active.channel.String()
+		}
+		ret[i].Switches = slices.Clone(g.switches)
+
+		for _, m := range g.members {
+			ret[i].Members = append(ret[i].Members, MemberStatus{
+				Channel: m.channel.String(),
+				Healthy: m.healthy,
+				Loss:    m.loss,
+			})
+		}
+	}
+	return ret
+}
+
+// DuplicateCount returns the number of duplicate frames discarded since startup.
+func (b *Bonder) DuplicateCount() uint64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.duplicateCount
+}
@@ -0,0 +1,238 @@
+package bonder
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gomavlib/v4"
+	"github.com/bluenviron/gomavlib/v4/pkg/dialects/common"
+	"github.com/bluenviron/gomavlib/v4/pkg/frame"
+	"github.com/bluenviron/gomavlib/v4/pkg/message"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bluenviron/mavp2p/pkg/hooks"
+	"github.com/bluenviron/mavp2p/pkg/linkstats"
+)
+
+func newEvent(ch *gomavlib.Channel, seq byte, msg message.Message) *gomavlib.EventFrame {
+	return &gomavlib.EventFrame{
+		Frame: &frame.V2Frame{
+			SequenceNumber: seq,
+			SystemID:       1,
+			ComponentID:    1,
+			Message:        msg,
+			Checksum:       uint16(seq) * 3,
+		},
+		Channel: ch,
+	}
+}
+
+func TestBonder(t *testing.T) {
+	primary := &gomavlib.EndpointSerial{}
+	backup := &gomavlib.EndpointUDPClient{}
+	gcs := &gomavlib.EndpointTCPServer{}
+
+	primaryCh := &gomavlib.Channel{}
+	backupCh := &gomavlib.Channel{}
+	gcsCh := &gomavlib.Channel{}
+
+	channelEndpoints := map[*gomavlib.Channel]gomavlib.Endpoint{
+		primaryCh: primary,
+		backupCh:  backup,
+		gcsCh:     gcs,
+	}
+	channelEndpoint = func(ch *gomavlib.Channel) gomavlib.Endpoint {
+		return channelEndpoints[ch]
+	}
+
+	for _, ca := range []string{"all", "best"} {
+		t.Run(ca, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			var wg sync.WaitGroup
+
+			ls := &linkstats.Tracker{
+				Ctx: ctx,
+				Wg:  &wg,
+			}
+			err := ls.Initialize()
+			require.NoError(t, err)
+
+			b := &Bonder{
+				Ctx:              ctx,
+				Wg:               &wg,
+				Groups:           [][]gomavlib.Endpoint{{primary, backup}},
+				HeartbeatTimeout: 5 * time.Second,
+				LinkStats:        ls,
+			}
+			if ca == "best" {
+				b.Policy = PolicyBest
+			}
+			err = b.Initialize()
+			require.NoError(t, err)
+
+			process := func(evt *gomavlib.EventFrame) bool {
+				ls.ProcessFrame(evt)
+				return b.ProcessFrame(evt)
+			}
+
+			for _, ch := range []*gomavlib.Channel{primaryCh, backupCh, gcsCh} {
+				b.ProcessChannelOpen(&gomavlib.EventChannelOpen{Channel: ch})
+			}
+
+			// deduplication
+			require.False(t, process(newEvent(primaryCh, 1, &common.MessageHeartbeat{})))
+			require.True(t, process(newEvent(backupCh, 1, &common.MessageHeartbeat{})))
+			require.False(t, process(newEvent(gcsCh, 1, &common.MessageHeartbeat{})))
+			require.Equal(t, uint64(1), b.DuplicateCount())
+
+			// backup loses frames
+			for seq := byte(2); seq < 20; seq++ {
+				require.False(t, process(newEvent(primaryCh, seq, &common.MessageAttitude{})))
+				if seq%4 == 0 {
+					require.False(t, process(newEvent(backupCh, seq, &common.MessageHeartbeat{})))
+				}
+			}
+			b.updateHealth()
+
+			// frames from the group are not routed back to the group
+			require.Equal(t, []*gomavlib.Channel{gcsCh},
+				b.Egress(primaryCh, []*gomavlib.Channel{backupCh, gcsCh}))
+
+			if ca == "all" {
+				require.Equal(t, []*gomavlib.Channel{primaryCh, backupCh},
+					b.Egress(gcsCh, []*gomavlib.Channel{primaryCh, backupCh}))
+			} else {
+				require.Equal(t, []*gomavlib.Channel{primaryCh},
+					b.Egress(gcsCh, []*gomavlib.Channel{backupCh, primaryCh}))
+			}
+
+			b.ProcessChannelClose(&gomavlib.EventChannelClose{Channel: primaryCh})
+
+			require.Equal(t, []*gomavlib.Channel{backupCh},
+				b.Egress(gcsCh, []*gomavlib.Channel{backupCh}))
+
+			cancel()
+			wg.Wait()
+		})
+	}
+}
+
+func TestBonderFailover(t *testing.T) {
+	primary := &gomavlib.EndpointSerial{}
+	backup := &gomavlib.EndpointUDPClient{}
+
+	primaryCh := &gomavlib.Channel{}
+	backupCh := &gomavlib.Channel{}
+
+	channelEndpoints := map[*gomavlib.Channel]gomavlib.Endpoint{
+		primaryCh: primary,
+		backupCh:  backup,
+	}
+	channelEndpoint = func(ch *gomavlib.Channel) gomavlib.Endpoint {
+		return channelEndpoints[ch]
+	}
+
+	now := time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time {
+		return now
+	}
+	defer func() {
+		timeNow = time.Now
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	ls := &linkstats.Tracker{
+		Ctx: ctx,
+		Wg:  &wg,
+	}
+	err := ls.Initialize()
+	require.NoError(t, err)
+
+	switched := make(chan map[string]string, 10)
+	s := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		err2 := json.NewDecoder(r.Body).Decode(&body)
+		require.NoError(t, err2)
+		switched <- body
+	}))
+	defer s.Close()
+
+	h := &hooks.Hooks{
+		Ctx:           ctx,
+		Wg:            &wg,
+		URL:           s.URL,
+		MaxConcurrent: 10,
+	}
+	err = h.Initialize()
+	require.NoError(t, err)
+
+	b := &Bonder{
+		Ctx:              ctx,
+		Wg:               &wg,
+		Groups:           [][]gomavlib.Endpoint{{primary, backup}},
+		Policy:           PolicyFailover,
+		HeartbeatTimeout: 3 * time.Second,
+		Hysteresis:       10 * time.Second,
+		LinkStats:        ls,
+		Hooks:            h,
+	}
+	err = b.Initialize()
+	require.NoError(t, err)
+
+	process := func(evt *gomavlib.EventFrame) bool {
+		ls.ProcessFrame(evt)
+		return b.ProcessFrame(evt)
+	}
+
+	b.ProcessChannelOpen(&gomavlib.EventChannelOpen{Channel: backupCh})
+	b.ProcessChannelOpen(&gomavlib.EventChannelOpen{Channel: primaryCh})
+
+	seq := byte(0)
+	step := func(heartbeatPrimary bool) {
+		now = now.Add(time.Second)
+		seq++
+		if heartbeatPrimary {
+			process(newEvent(primaryCh, seq, &common.MessageHeartbeat{}))
+		}
+		process(newEvent(backupCh, seq, &common.MessageHeartbeat{}))
+		b.updateHealth()
+	}
+
+	step(true)
+	require.Equal(t, []*gomavlib.Channel{primaryCh}, b.Egress(nil, []*gomavlib.Channel{backupCh}))
+
+	// primary goes silent
+	for range 4 {
+		step(false)
+	}
+	require.Equal(t, []*gomavlib.Channel{backupCh}, b.Egress(nil, []*gomavlib.Channel{primaryCh}))
+
+	// primary recovers, hysteresis
+	for range 5 {
+		step(true)
+	}
+	require.Equal(t, []*gomavlib.Channel{backupCh}, b.Egress(nil, []*gomavlib.Channel{primaryCh}))
+
+	for range 6 {
+		step(true)
+	}
+	require.Equal(t, []*gomavlib.Channel{primaryCh}, b.Egress(nil, []*gomavlib.Channel{primaryCh}))
+
+	status := b.Status()
+	require.Len(t, status[0].Switches, 3)
+
+	for range 3 {
+		body := <-switched
+		require.Equal(t, hooks.EventBondSwitched, body["event"])
+	}
+
+	cancel()
+	wg.Wait()
+}
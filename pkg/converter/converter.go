@@ -0,0 +1,224 @@
+// Package converter contains the protocol version converter.
+package converter
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gomavlib/v4"
+	"github.com/bluenviron/gomavlib/v4/pkg/dialect"
+	"github.com/bluenviron/gomavlib/v4/pkg/frame"
+	"github.com/bluenviron/gomavlib/v4/pkg/message"
+
+	"github.com/bluenviron/mavp2p/pkg/logger"
+)
+
+const reportPeriod = 10 * time.Second
+
+var channelEndpoint = func(ch *gomavlib.Channel) gomavlib.Endpoint { return ch.Endpoint() }
+
+// OutVersion is the Mavlink version of frames sent to an endpoint.
+type OutVersion struct {
+	Endpoint gomavlib.Endpoint
+	Version  gomavlib.Version
+}
+
+type channelCounters struct {
+	converted uint64
+	dropped   uint64
+	reported  uint64
+}
+
+// ChannelStats are the conversion statistics of a channel.
+type ChannelStats struct {
+	Channel   string
+	Converted uint64
+	Dropped   uint64
+}
+
+// Converter converts frames sent to specific endpoints to another Mavlink version.
+// Extensions are removed when converting to Mavlink 1.
+// Frames of messages with an ID greater than 255 or outside the dialect cannot be converted and are dropped.
+type Converter struct {
+	Ctx         context.Context
+	Wg          *sync.WaitGroup
+	OutVersions []OutVersion
+
+	// dialect used to encode and decode messages.
+	Dialect *dialect.Dialect
+
+	Log *slog.Logger
+
+	versions  map[gomavlib.Endpoint]gomavlib.Version
+	dialectRW *dialect.ReadWriter
+
+	mutex    sync.Mutex
+	counters map[*gomavlib.Channel]*channelCounters
+}
+
+// Initialize initializes a Converter.
+func (c *Converter) Initialize() error {
+	if c.Log == nil {
+		c.Log = slog.Default()
+	}
+
+	c.versions = make(map[gomavlib.Endpoint]gomavlib.Version)
+	for _, v := range c.OutVersions {
+		c.versions[v.Endpoint] = v.Version
+	}
+
+	c.dialectRW = &dialect.ReadWriter{Dialect: c.Dialect}
+	err := c.dialectRW.Initialize()
+	if err != nil {
+		return err
+	}
+
+	c.counters = make(map[*gomavlib.Channel]*channelCounters)
+
+	c.Wg.Add(1)
+	go c.run()
+
+	return nil
+}
+
+func (c *Converter) run() {
+	defer c.Wg.Done()
+
+	ticker := time.NewTicker(reportPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.report()
+
+		case <-c.Ctx.Done():
+			return
+		}
+	}
+}
+
+// report logs frames that have been dropped since the last report.
+func (c *Converter) report() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for ch, cc := range c.counters {
+		if n := cc.dropped - cc.reported; n != 0 {
+			c.Log.Warn("frames cannot be converted, dropped", logger.Channel(ch), slog.Uint64("count", n))
+		}
+		cc.reported = cc.dropped
+	}
+}
+
+func (c *Converter) convert(fr frame.Frame, toV2 bool) (frame.Frame, error) {
+	msg := fr.GetMessage()
+
+	if !toV2 && msg.GetID() > 255 {
+		return nil, fmt.Errorf("message ID %d does not fit into a Mavlink 1 frame", msg.GetID())
+	}
+
+	mrw := c.dialectRW.GetMessage(msg.GetID())
+	if mrw == nil {
+		return nil, fmt.Errorf("message ID %d is not in the dialect", msg.GetID())
+	}
+
+	if raw, ok := msg.(*message.MessageRaw); ok {
+		var err error
+		msg, err = mrw.Read(raw, !toV2)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	raw := mrw.Write(msg, toV2)
+
+	if toV2 {
+		out := &frame.V2Frame{
+			SequenceNumber: fr.GetSequenceNumber(),
+			SystemID:       fr.GetSystemID(),
+			ComponentID:    fr.GetComponentID(),
+			Message:        raw,
+		}
+		out.Checksum = out.GenerateChecksum(mrw.CRCExtra())
+		return out, nil
+	}
+
+	out := &frame.V1Frame{
+		SequenceNumber: fr.GetSequenceNumber(),
+		SystemID:       fr.GetSystemID(),
+		ComponentID:    fr.GetComponentID(),
+		Message:        raw,
+	}
+	out.Checksum = out.GenerateChecksum(mrw.CRCExtra())
+	return out, nil
+}
+
+// Convert converts a frame to the version of the endpoint of a channel.
+// It returns nil when the frame cannot be converted.
+func (c *Converter) Convert(ch *gomavlib.Channel, fr frame.Frame) frame.Frame {
+	version, ok := c.versions[channelEndpoint(ch)]
+	if !ok {
+		return fr
+	}
+
+	_, isV2 := fr.(*frame.V2Frame)
+	toV2 := version == gomavlib.V2
+	if isV2 == toV2 {
+		return fr
+	}
+
+	out, err := c.convert(fr, toV2)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	cc, ok := c.counters[ch]
+	if !ok {
+		cc = &channelCounters{}
+		c.counters[ch] = cc
+	}
+
+	if err != nil {
+		c.Log.Debug("unable to convert frame", logger.Channel(ch), slog.Any("error", err))
+		cc.dropped++
+		return nil
+	}
+
+	cc.converted++
+	return out
+}
+
+// ProcessChannelClose processes a EventChannelClose.
+func (c *Converter) ProcessChannelClose(evt *gomavlib.EventChannelClose) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.counters, evt.Channel)
+}
+
+// Stats returns the conversion statistics of channels.
+func (c *Converter) Stats() []ChannelStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	ret := make([]ChannelStats, 0, len(c.counters))
+
+	for ch, cc := range c.counters {
+		ret = append(ret, ChannelStats{
+			Channel:   ch.String(),
+			Converted: cc.converted,
+			Dropped:   cc.dropped,
+		})
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Channel < ret[j].Channel
+	})
+
+	return ret
+}
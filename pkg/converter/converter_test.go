@@ -0,0 +1,137 @@
+package converter
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/bluenviron/gomavlib/v4"
+	"github.com/bluenviron/gomavlib/v4/pkg/dialect"
+	"github.com/bluenviron/gomavlib/v4/pkg/dialects/common"
+	"github.com/bluenviron/gomavlib/v4/pkg/frame"
+	"github.com/bluenviron/gomavlib/v4/pkg/message"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConverter(t *testing.T) {
+	tracker := &gomavlib.EndpointSerial{}
+	gcs := &gomavlib.EndpointUDPServer{}
+	other := &gomavlib.EndpointTCPServer{}
+	trackerCh := &gomavlib.Channel{}
+	gcsCh := &gomavlib.Channel{}
+	otherCh := &gomavlib.Channel{}
+
+	channelEndpoints := map[*gomavlib.Channel]gomavlib.Endpoint{
+		trackerCh: tracker,
+		gcsCh:     gcs,
+		otherCh:   other,
+	}
+	channelEndpoint = func(ch *gomavlib.Channel) gomavlib.Endpoint {
+		return channelEndpoints[ch]
+	}
+	defer func() { channelEndpoint = func(ch *gomavlib.Channel) gomavlib.Endpoint { return ch.Endpoint() } }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	c := &Converter{
+		Ctx: ctx,
+		Wg:  &wg,
+		OutVersions: []OutVersion{
+			{Endpoint: tracker, Version: gomavlib.V1},
+			{Endpoint: gcs, Version: gomavlib.V2},
+		},
+		Dialect: common.Dialect,
+	}
+	err := c.Initialize()
+	require.NoError(t, err)
+
+	dialectRW := &dialect.ReadWriter{Dialect: common.Dialect}
+	err = dialectRW.Initialize()
+	require.NoError(t, err)
+
+	decode := func(fr frame.Frame) message.Message {
+		raw := fr.GetMessage().(*message.MessageRaw)
+		_, isV2 := fr.(*frame.V2Frame)
+		msg, err2 := dialectRW.GetMessage(raw.ID).Read(raw, isV2)
+		require.NoError(t, err2)
+		return msg
+	}
+
+	ack := &common.MessageCommandAck{
+		Command:         common.MAV_CMD_SET_MESSAGE_INTERVAL,
+		Result:          common.MAV_RESULT_ACCEPTED,
+		Progress:        10,
+		TargetSystem:    255,
+		TargetComponent: 190,
+	}
+
+	v2Frame := &frame.V2Frame{
+		SequenceNumber: 12,
+		SystemID:       1,
+		ComponentID:    2,
+		Message:        ack,
+	}
+
+	// frames sent to other endpoints are not converted
+	require.Same(t, v2Frame, c.Convert(otherCh, v2Frame))
+	require.Same(t, v2Frame, c.Convert(gcsCh, v2Frame))
+
+	// v2 to v1, extensions are removed
+	out := c.Convert(trackerCh, v2Frame)
+	v1Frame, ok := out.(*frame.V1Frame)
+	require.True(t, ok)
+	require.Equal(t, byte(12), v1Frame.SequenceNumber)
+	require.Equal(t, byte(1), v1Frame.SystemID)
+	require.Equal(t, byte(2), v1Frame.ComponentID)
+	require.Equal(t, v1Frame.GenerateChecksum(dialectRW.GetMessage(77).CRCExtra()), v1Frame.Checksum)
+	require.Equal(t, &common.MessageCommandAck{
+		Command: common.MAV_CMD_SET_MESSAGE_INTERVAL,
+		Result:  common.MAV_RESULT_ACCEPTED,
+	}, decode(v1Frame))
+
+	// v2 to v1 of a message that has not been decoded
+	attitude := &common.MessageAttitude{TimeBootMs: 1000, Roll: 0.1, Pitch: 0.2, Yaw: 0.3, Rollspeed: 0.4}
+	out = c.Convert(trackerCh, &frame.V2Frame{
+		SystemID:    1,
+		ComponentID: 1,
+		Message:     dialectRW.GetMessage(30).Write(attitude, true),
+	})
+	require.Equal(t, attitude, decode(out))
+
+	// v1 to v2, fields are preserved
+	heartbeat := &common.MessageHeartbeat{
+		Type:           common.MAV_TYPE_ANTENNA_TRACKER,
+		Autopilot:      common.MAV_AUTOPILOT_GENERIC,
+		CustomMode:     7,
+		SystemStatus:   common.MAV_STATE_ACTIVE,
+		MavlinkVersion: 3,
+	}
+	out = c.Convert(gcsCh, &frame.V1Frame{
+		SequenceNumber: 5,
+		SystemID:       3,
+		ComponentID:    4,
+		Message:        heartbeat,
+	})
+	v2Out, ok := out.(*frame.V2Frame)
+	require.True(t, ok)
+	require.Equal(t, byte(5), v2Out.SequenceNumber)
+	require.Equal(t, byte(3), v2Out.SystemID)
+	require.Equal(t, byte(4), v2Out.ComponentID)
+	require.Equal(t, heartbeat, decode(v2Out))
+
+	// messages with an ID greater than 255 or outside the dialect are dropped
+	require.Nil(t, c.Convert(trackerCh, &frame.V2Frame{Message: &common.MessageOdometry{}}))
+	require.Nil(t, c.Convert(trackerCh, &frame.V2Frame{Message: &message.MessageRaw{ID: 255, Payload: []byte{1}}}))
+
+	require.ElementsMatch(t, []ChannelStats{
+		{Converted: 1},
+		{Converted: 2, Dropped: 2},
+	}, c.Stats())
+
+	c.ProcessChannelClose(&gomavlib.EventChannelClose{Channel: trackerCh})
+	require.Len(t, c.Stats(), 1)
+
+	cancel()
+	wg.Wait()
+}
@@ -0,0 +1,137 @@
+// Package crcverifier contains the checksum verifier.
+package crcverifier
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gomavlib/v4"
+	"github.com/bluenviron/gomavlib/v4/pkg/dialect"
+	"github.com/bluenviron/gomavlib/v4/pkg/message"
+
+	"github.com/bluenviron/mavp2p/pkg/errorman"
+	"github.com/bluenviron/mavp2p/pkg/logger"
+)
+
+const reportPeriod = 10 * time.Second
+
+// Verifier verifies checksums of frames that have not been decoded,
+// using the CRC_EXTRA of messages of a dialect.
+// Frames of messages outside the dialect are not verified.
+type Verifier struct {
+	Ctx     context.Context
+	Wg      *sync.WaitGroup
+	Dialect *dialect.Dialect
+	Log     *slog.Logger
+
+	// used to account frames with invalid checksums. It can be nil.
+	ErrorMan *errorman.Manager
+
+	crcExtras map[uint32]byte
+
+	mutex    sync.Mutex
+	invalid  map[*gomavlib.Channel]uint64
+	reported map[*gomavlib.Channel]uint64
+}
+
+// Initialize initializes a Verifier.
+func (v *Verifier) Initialize() error {
+	if v.Log == nil {
+		v.Log = slog.Default()
+	}
+
+	dialectRW := &dialect.ReadWriter{Dialect: v.Dialect}
+	err := dialectRW.Initialize()
+	if err != nil {
+		return err
+	}
+
+	v.crcExtras = make(map[uint32]byte)
+	for _, msg := range v.Dialect.Messages {
+		if mrw := dialectRW.GetMessage(msg.GetID()); mrw != nil {
+			v.crcExtras[msg.GetID()] = mrw.CRCExtra()
+		}
+	}
+
+	v.invalid = make(map[*gomavlib.Channel]uint64)
+	v.reported = make(map[*gomavlib.Channel]uint64)
+
+	v.Wg.Add(1)
+	go v.run()
+
+	return nil
+}
+
+func (v *Verifier) run() {
+	defer v.Wg.Done()
+
+	ticker := time.NewTicker(reportPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			v.report()
+
+		case <-v.Ctx.Done():
+			return
+		}
+	}
+}
+
+// report logs frames that have been discarded since the last report.
+func (v *Verifier) report() {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	for ch, n := range v.invalid {
+		if d := n - v.reported[ch]; d != 0 {
+			v.Log.Warn("invalid checksums, frames discarded", logger.Channel(ch), slog.Uint64("count", d))
+		}
+		v.reported[ch] = n
+	}
+}
+
+// ProcessFrame processes a EventFrame.
+// It returns true if the frame has an invalid checksum and must be discarded.
+func (v *Verifier) ProcessFrame(evt *gomavlib.EventFrame) bool {
+	raw, ok := evt.Message().(*message.MessageRaw)
+	if !ok {
+		return false
+	}
+
+	crcExtra, ok := v.crcExtras[raw.ID]
+	if !ok {
+		return false
+	}
+
+	checksum := evt.Frame.GenerateChecksum(crcExtra)
+	if checksum == evt.Frame.GetChecksum() {
+		return false
+	}
+
+	if v.ErrorMan != nil {
+		v.ErrorMan.ProcessInvalidFrame(evt, errorman.CategoryBadCRC,
+			fmt.Errorf("wrong checksum, expected %.4x, got %.4x, message id is %d",
+				checksum, evt.Frame.GetChecksum(), raw.ID))
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.invalid[evt.Channel]++
+
+	return true
+}
+
+// ProcessChannelClose processes a EventChannelClose.
+func (v *Verifier) ProcessChannelClose(evt *gomavlib.EventChannelClose) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	delete(v.invalid, evt.Channel)
+	delete(v.reported, evt.Channel)
+}
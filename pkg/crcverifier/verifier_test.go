@@ -0,0 +1,68 @@
+package crcverifier
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/bluenviron/gomavlib/v4"
+	"github.com/bluenviron/gomavlib/v4/pkg/dialect"
+	"github.com/bluenviron/gomavlib/v4/pkg/dialects/common"
+	"github.com/bluenviron/gomavlib/v4/pkg/frame"
+	"github.com/bluenviron/gomavlib/v4/pkg/message"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifier(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	v := &Verifier{
+		Ctx:     ctx,
+		Wg:      &wg,
+		Dialect: common.Dialect,
+	}
+	err := v.Initialize()
+	require.NoError(t, err)
+
+	dialectRW := &dialect.ReadWriter{Dialect: common.Dialect}
+	err = dialectRW.Initialize()
+	require.NoError(t, err)
+
+	ch := &gomavlib.Channel{}
+
+	newFrame := func(id uint32, crcExtra byte, corrupt bool) *gomavlib.EventFrame {
+		fr := &frame.V2Frame{
+			SystemID:    1,
+			ComponentID: 1,
+			Message:     &message.MessageRaw{ID: id, Payload: []byte{1, 2, 3, 4}},
+		}
+		fr.Checksum = fr.GenerateChecksum(crcExtra)
+		if corrupt {
+			fr.Checksum++
+		}
+		return &gomavlib.EventFrame{Frame: fr, Channel: ch}
+	}
+
+	crcExtra := dialectRW.GetMessage(30).CRCExtra()
+
+	require.False(t, v.ProcessFrame(newFrame(30, crcExtra, false)))
+	require.True(t, v.ProcessFrame(newFrame(30, crcExtra, true)))
+
+	// messages outside the dialect are not verified
+	require.False(t, v.ProcessFrame(newFrame(50000, 0, true)))
+
+	// decoded messages are not verified
+	require.False(t, v.ProcessFrame(&gomavlib.EventFrame{
+		Frame:   &frame.V2Frame{Message: &common.MessageAttitude{}},
+		Channel: ch,
+	}))
+
+	require.Equal(t, uint64(1), v.invalid[ch])
+
+	v.ProcessChannelClose(&gomavlib.EventChannelClose{Channel: ch})
+	require.Empty(t, v.invalid)
+
+	cancel()
+	wg.Wait()
+}
@@ -0,0 +1,191 @@
+// Package decimator contains the message decimator.
+package decimator
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gomavlib/v4"
+	"github.com/bluenviron/gomavlib/v4/pkg/frame"
+
+	"github.com/bluenviron/mavp2p/pkg/shaper"
+	"github.com/bluenviron/mavp2p/pkg/writer"
+)
+
+var (
+	timeNow         = time.Now
+	flushPeriod     = 10 * time.Millisecond
+	channelEndpoint = func(ch *gomavlib.Channel) gomavlib.Endpoint { return ch.Endpoint() }
+)
+
+// Limit is the maximum rate of a message sent to an endpoint.
+type Limit struct {
+	Endpoint  gomavlib.Endpoint
+	MessageID uint32
+
+	// maximum rate in Hz.
+	Rate float64
+}
+
+type limitKey struct {
+	endpoint  gomavlib.Endpoint
+	messageID uint32
+}
+
+type slotKey struct {
+	channel     *gomavlib.Channel
+	messageID   uint32
+	systemID    byte
+	componentID byte
+}
+
+type slot struct {
+	interval time.Duration
+	lastSent time.Time
+	pending  frame.Frame
+}
+
+// Decimator caps the rate of messages sent to specific endpoints.
+// Excess frames are discarded, except the latest one, that is sent
+// as soon as the rate allows it.
+type Decimator struct {
+	Ctx    context.Context
+	Wg     *sync.WaitGroup
+	Limits []Limit
+
+	// pending frames are passed to the shaper before being sent. It can be nil.
+	Shaper *shaper.Shaper
+
+	// pending frames are queued into the writer, that accounts them once sent.
+	Writer *writer.Writer
+
+	intervals map[limitKey]time.Duration
+
+	mutex sync.Mutex
+	slots map[slotKey]*slot
+}
+
+// Initialize initializes a Decimator.
+func (d *Decimator) Initialize() error {
+	d.intervals = make(map[limitKey]time.Duration)
+	for _, l := range d.Limits {
+		d.intervals[limitKey{l.Endpoint, l.MessageID}] = time.Duration(float64(time.Second) / l.Rate)
+	}
+
+	d.slots = make(map[slotKey]*slot)
+
+	d.Wg.Add(1)
+	go d.run()
+
+	return nil
+}
+
+func (d *Decimator) run() {
+	defer d.Wg.Done()
+
+	ticker := time.NewTicker(flushPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			d.flush()
+
+		case <-d.Ctx.Done():
+			return
+		}
+	}
+}
+
+type pendingFrame struct {
+	channel *gomavlib.Channel
+	frame   frame.Frame
+}
+
+// dueFrames returns pending frames whose interval has elapsed, and marks them as sent.
+func (d *Decimator) dueFrames() []pendingFrame {
+	now := timeNow()
+	var ret []pendingFrame
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for key, s := range d.slots {
+		if s.pending != nil && now.Sub(s.lastSent) >= s.interval {
+			ret = append(ret, pendingFrame{key.channel, s.pending})
+			s.pending = nil
+			s.lastSent = now
+		}
+	}
+
+	return ret
+}
+
+// flush sends pending frames whose interval has elapsed.
+func (d *Decimator) flush() {
+	for _, p := range d.dueFrames() {
+		targets := []*gomavlib.Channel{p.channel}
+
+		if d.Shaper != nil {
+			targets = d.Shaper.Egress(p.frame, targets)
+		}
+
+		for _, ch := range targets {
+			d.Writer.Write(ch, p.frame)
+		}
+	}
+}
+
+// Egress filters the channels a frame is about to be sent to,
+// removing the ones whose rate limit has been reached.
+func (d *Decimator) Egress(fr frame.Frame, targets []*gomavlib.Channel) []*gomavlib.Channel {
+	messageID := fr.GetMessage().GetID()
+	now := timeNow()
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	ret := make([]*gomavlib.Channel, 0, len(targets))
+
+	for _, ch := range targets {
+		interval, ok := d.intervals[limitKey{channelEndpoint(ch), messageID}]
+		if !ok {
+			ret = append(ret, ch)
+			continue
+		}
+
+		// limits are applied to each source separately,
+		// in order not to discard messages of other vehicles.
+		key := slotKey{ch, messageID, fr.GetSystemID(), fr.GetComponentID()}
+
+		s, ok := d.slots[key]
+		if !ok {
+			s = &slot{interval: interval}
+			d.slots[key] = s
+		}
+
+		if s.pending == nil && now.Sub(s.lastSent) >= s.interval {
+			s.lastSent = now
+			ret = append(ret, ch)
+			continue
+		}
+
+		// keep the latest sample only
+		s.pending = fr
+	}
+
+	return ret
+}
+
+// ProcessChannelClose processes a EventChannelClose.
+func (d *Decimator) ProcessChannelClose(evt *gomavlib.EventChannelClose) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for key := range d.slots {
+		if key.channel == evt.Channel {
+			delete(d.slots, key)
+		}
+	}
+}
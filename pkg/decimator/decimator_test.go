@@ -0,0 +1,92 @@
+package decimator
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gomavlib/v4"
+	"github.com/bluenviron/gomavlib/v4/pkg/dialects/common"
+	"github.com/bluenviron/gomavlib/v4/pkg/frame"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecimator(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	flushPeriod = time.Hour
+	defer func() { flushPeriod = 10 * time.Millisecond }()
+
+	relay := &gomavlib.EndpointUDPClient{}
+	lte := &gomavlib.EndpointUDPServer{}
+	relayCh := &gomavlib.Channel{}
+	lteCh := &gomavlib.Channel{}
+
+	channelEndpoints := map[*gomavlib.Channel]gomavlib.Endpoint{
+		relayCh: relay,
+		lteCh:   lte,
+	}
+	channelEndpoint = func(ch *gomavlib.Channel) gomavlib.Endpoint {
+		return channelEndpoints[ch]
+	}
+	defer func() { channelEndpoint = func(ch *gomavlib.Channel) gomavlib.Endpoint { return ch.Endpoint() } }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	d := &Decimator{
+		Ctx:    ctx,
+		Wg:     &wg,
+		Limits: []Limit{{Endpoint: relay, MessageID: 30, Rate: 4}},
+	}
+	err := d.Initialize()
+	require.NoError(t, err)
+
+	attitude := func(seq byte) frame.Frame {
+		return &frame.V2Frame{
+			SequenceNumber: seq,
+			SystemID:       1,
+			ComponentID:    1,
+			Message:        &common.MessageAttitude{},
+		}
+	}
+
+	targets := []*gomavlib.Channel{relayCh, lteCh}
+
+	require.Equal(t, targets, d.Egress(attitude(0), targets))
+
+	// excess frames are discarded on the limited endpoint only
+	now = now.Add(100 * time.Millisecond)
+	require.Equal(t, []*gomavlib.Channel{lteCh}, d.Egress(attitude(1), targets))
+
+	now = now.Add(100 * time.Millisecond)
+	require.Equal(t, []*gomavlib.Channel{lteCh}, d.Egress(attitude(2), targets))
+
+	// other messages are not limited
+	hb := &frame.V2Frame{SystemID: 1, ComponentID: 1, Message: &common.MessageHeartbeat{}}
+	require.Equal(t, targets, d.Egress(hb, targets))
+
+	// other sources are limited separately
+	other := &frame.V2Frame{SystemID: 2, ComponentID: 1, Message: &common.MessageAttitude{}}
+	require.Equal(t, targets, d.Egress(other, targets))
+
+	require.Empty(t, d.dueFrames())
+
+	// the latest sample is sent when the interval elapses
+	now = now.Add(50 * time.Millisecond)
+	require.Equal(t, []pendingFrame{{relayCh, attitude(2)}}, d.dueFrames())
+	require.Empty(t, d.dueFrames())
+
+	now = now.Add(100 * time.Millisecond)
+	require.Equal(t, []*gomavlib.Channel{lteCh}, d.Egress(attitude(3), targets))
+
+	d.ProcessChannelClose(&gomavlib.EventChannelClose{Channel: relayCh})
+	now = now.Add(time.Second)
+	require.Empty(t, d.dueFrames())
+
+	cancel()
+	wg.Wait()
+}
@@ -3,7 +3,7 @@ package dumper
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"sync"
@@ -27,6 +27,7 @@ type Dumper struct {
 	Dialect      *dialect.Dialect
 	DumpPath     string
 	DumpDuration time.Duration
+	Log          *slog.Logger
 
 	dialectRW  *dialect.ReadWriter
 	file       *os.File
@@ -38,6 +39,10 @@ type Dumper struct {
 
 // Initialize initializes a Dumper.
 func (m *Dumper) Initialize() error {
+	if m.Log == nil {
+		m.Log = slog.Default()
+	}
+
 	m.dialectRW = &dialect.ReadWriter{Dialect: m.Dialect}
 	err := m.dialectRW.Initialize()
 	if err != nil {
@@ -119,6 +124,6 @@ func (m *Dumper) ProcessFrame(evt *gomavlib.EventFrame) {
 	}:
 	case <-m.Ctx.Done():
 	default:
-		log.Printf("WARN: disk is too slow, discarding frame")
+		m.Log.Warn("disk is too slow, discarding frame")
 	}
 }
@@ -3,27 +3,185 @@ package errorman
 
 import (
 	"context"
-	"log"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/bluenviron/gomavlib/v4"
+	"github.com/bluenviron/gomavlib/v4/pkg/dialect"
+	"github.com/bluenviron/gomavlib/v4/pkg/frame"
+	"github.com/bluenviron/gomavlib/v4/pkg/message"
+
+	"github.com/bluenviron/mavp2p/pkg/hooks"
+	"github.com/bluenviron/mavp2p/pkg/logger"
 )
 
 var printInterval = 5 * time.Second
 
+// Category is a parse error category.
+type Category int
+
+// categories.
+const (
+	CategoryOther Category = iota
+	CategoryInvalidMagic
+	CategoryBadCRC
+	CategoryUnknownMessage
+	CategoryTruncatedFrame
+	CategorySignature
+)
+
+var categoryLabels = map[Category]string{
+	CategoryOther:          "other",
+	CategoryInvalidMagic:   "invalid_magic",
+	CategoryBadCRC:         "bad_crc",
+	CategoryUnknownMessage: "unknown_message",
+	CategoryTruncatedFrame: "truncated_frame",
+	CategorySignature:      "signature",
+}
+
+// String implements fmt.Stringer.
+func (c Category) String() string {
+	return categoryLabels[c]
+}
+
+// gomavlib parse errors share a single type, therefore they are categorized by their description:
+// "invalid magic byte", "wrong checksum", signature errors, and errors about truncated frames,
+// that wrap io.ErrUnexpectedEOF or mention EOF, truncation, lengths or short frames.
+// Messages that are not in the dialect do not cause parse errors, since they are decoded as raw messages,
+// therefore they are categorized by ProcessFrame.
+func categorize(err error) Category {
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return CategoryTruncatedFrame
+	}
+
+	s := strings.ToLower(err.Error())
+
+	switch {
+	case strings.Contains(s, "magic"):
+		return CategoryInvalidMagic
+
+	case strings.Contains(s, "signature"):
+		return CategorySignature
+
+	case strings.Contains(s, "checksum") || strings.Contains(s, "crc"):
+		return CategoryBadCRC
+
+	case strings.Contains(s, "eof") || strings.Contains(s, "truncated") ||
+		strings.Contains(s, "length") || strings.Contains(s, "too short"):
+		return CategoryTruncatedFrame
+	}
+
+	return CategoryOther
+}
+
+// frameBytes returns the encoded bytes of a frame whose message has not been decoded.
+func frameBytes(fr frame.Frame) ([]byte, bool) {
+	raw, ok := fr.GetMessage().(*message.MessageRaw)
+	if !ok {
+		return nil, false
+	}
+
+	var buf []byte
+
+	switch fr := fr.(type) {
+	case *frame.V1Frame:
+		buf = append(buf, 0xFE, byte(len(raw.Payload)), fr.SequenceNumber, fr.SystemID, fr.ComponentID,
+			byte(raw.ID))
+		buf = append(buf, raw.Payload...)
+		buf = binary.LittleEndian.AppendUint16(buf, fr.Checksum)
+
+	case *frame.V2Frame:
+		buf = append(buf, 0xFD, byte(len(raw.Payload)), fr.IncompatibilityFlag, fr.CompatibilityFlag,
+			fr.SequenceNumber, fr.SystemID, fr.ComponentID, byte(raw.ID), byte(raw.ID>>8), byte(raw.ID>>16))
+		buf = append(buf, raw.Payload...)
+		buf = binary.LittleEndian.AppendUint16(buf, fr.Checksum)
+
+		if fr.Signature != nil {
+			var timestamp [8]byte
+			binary.LittleEndian.PutUint64(timestamp[:], fr.SignatureTimestamp)
+
+			buf = append(buf, fr.SignatureLinkID)
+			buf = append(buf, timestamp[:6]...)
+			buf = append(buf, fr.Signature[:]...)
+		}
+
+	default:
+		return nil, false
+	}
+
+	return buf, true
+}
+
+type errorKey struct {
+	channel  string
+	category Category
+}
+
+// Stats are the error statistics of a channel.
+type Stats struct {
+	Channel  string
+	Category Category
+	Count    uint64
+}
+
 // Manager is a error manager.
 type Manager struct {
 	Ctx               context.Context
 	Wg                *sync.WaitGroup
 	PrintSingleErrors bool
+	SummaryPeriod     time.Duration
+	Log               *slog.Logger
+
+	// fire a hook when the errors received by a channel
+	// within SummaryPeriod reach this threshold. Zero disables it.
+	Hooks          *hooks.Hooks
+	HooksThreshold uint64
+
+	// messages outside this dialect are counted in the unknown_message category.
+	// It can be nil.
+	Dialect *dialect.Dialect
 
-	errorCount      int
-	errorCountMutex sync.Mutex
+	// include a hex dump of the offending frame in single errors.
+	// Parse errors do not carry the bytes they were raised from,
+	// therefore only unknown messages and frames with invalid checksums are dumped.
+	HexDump bool
+
+	knownMessages map[uint32]struct{}
+
+	mutex        sync.Mutex
+	periodCounts map[errorKey]uint64
+	totalCounts  map[errorKey]uint64
 }
 
 // Initialize initializes a Manager.
 func (m *Manager) Initialize() error {
+	if m.Log == nil {
+		m.Log = slog.Default()
+	}
+
+	if m.SummaryPeriod == 0 {
+		m.SummaryPeriod = printInterval
+	}
+
+	if m.Dialect != nil {
+		m.knownMessages = make(map[uint32]struct{})
+		for _, msg := range m.Dialect.Messages {
+			m.knownMessages[msg.GetID()] = struct{}{}
+		}
+	}
+
+	m.periodCounts = make(map[errorKey]uint64)
+	m.totalCounts = make(map[errorKey]uint64)
+
 	m.Wg.Add(1)
 	go m.run()
 
@@ -33,25 +191,17 @@ func (m *Manager) Initialize() error {
 func (m *Manager) run() {
 	defer m.Wg.Done()
 
-	if m.PrintSingleErrors {
+	if !m.summaryEnabled() {
 		return
 	}
 
-	t := time.NewTicker(printInterval)
+	t := time.NewTicker(m.SummaryPeriod)
 	defer t.Stop()
 
 	for {
 		select {
 		case <-t.C:
-			func() {
-				m.errorCountMutex.Lock()
-				defer m.errorCountMutex.Unlock()
-
-				if m.errorCount > 0 {
-					log.Printf("%d errors in the last %s", m.errorCount, printInterval)
-					m.errorCount = 0
-				}
-			}()
+			m.printSummary()
 
 		case <-m.Ctx.Done():
 			return
@@ -59,14 +209,158 @@ func (m *Manager) run() {
 	}
 }
 
+// period counts are needed to print the summary and to fire hooks.
+func (m *Manager) summaryEnabled() bool {
+	return !m.PrintSingleErrors || (m.Hooks != nil && m.HooksThreshold != 0)
+}
+
+func (m *Manager) printSummary() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if len(m.periodCounts) == 0 {
+		return
+	}
+
+	channelTotals := make(map[string]uint64)
+	for key, n := range m.totalCounts {
+		channelTotals[key.channel] += n
+	}
+
+	var channel string
+	var count uint64
+	var attrs []any
+
+	flush := func() {
+		attrs = append([]any{
+			slog.String(logger.FieldChannel, channel),
+			slog.Uint64("count", count),
+			slog.Duration("period", m.SummaryPeriod),
+			slog.Uint64("total", channelTotals[channel]),
+		}, attrs...)
+		if !m.PrintSingleErrors {
+			m.Log.Warn("parse errors", attrs...)
+		}
+
+		if m.Hooks != nil && m.HooksThreshold != 0 && count >= m.HooksThreshold {
+			m.Hooks.Fire(hooks.Event{
+				Type: hooks.EventParseErrors,
+				Fields: map[string]string{
+					logger.FieldChannel: channel,
+					"count":             strconv.FormatUint(count, 10),
+					"period":            m.SummaryPeriod.String(),
+				},
+			})
+		}
+	}
+
+	for i, key := range sortedKeys(m.periodCounts) {
+		if i != 0 && key.channel != channel {
+			flush()
+			count = 0
+			attrs = nil
+		}
+
+		n := m.periodCounts[key]
+		channel = key.channel
+		count += n
+		attrs = append(attrs, slog.Uint64(key.category.String(), n))
+	}
+	flush()
+
+	clear(m.periodCounts)
+}
+
+func sortedKeys(counts map[errorKey]uint64) []errorKey {
+	keys := make([]errorKey, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].channel != keys[j].channel {
+			return keys[i].channel < keys[j].channel
+		}
+		return keys[i].category < keys[j].category
+	})
+
+	return keys
+}
+
+func (m *Manager) add(ch *gomavlib.Channel, category Category, err error, fr frame.Frame) {
+	key := errorKey{
+		channel:  fmt.Sprint(ch),
+		category: category,
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.totalCounts[key]++
+
+	if m.summaryEnabled() {
+		m.periodCounts[key]++
+	}
+
+	if m.PrintSingleErrors {
+		attrs := []any{
+			slog.String(logger.FieldChannel, key.channel),
+			slog.String("category", key.category.String()),
+			slog.Any("error", err),
+		}
+
+		if m.HexDump && fr != nil {
+			if buf, ok := frameBytes(fr); ok {
+				attrs = append(attrs, slog.String("bytes", hex.EncodeToString(buf)))
+			}
+		}
+
+		m.Log.Warn("parse error", attrs...)
+	}
+}
+
 // ProcessError processes a EventParseError.
 func (m *Manager) ProcessError(evt *gomavlib.EventParseError) {
-	if m.PrintSingleErrors {
-		log.Printf("ERR: %s", evt.Error)
+	m.add(evt.Channel, categorize(evt.Error), evt.Error, nil)
+}
+
+// ProcessFrame processes a EventFrame.
+// Frames of messages outside the dialect are counted as unknown messages.
+func (m *Manager) ProcessFrame(evt *gomavlib.EventFrame) {
+	if m.knownMessages == nil {
 		return
 	}
 
-	m.errorCountMutex.Lock()
-	defer m.errorCountMutex.Unlock()
-	m.errorCount++
+	id := evt.Message().GetID()
+	if _, ok := m.knownMessages[id]; ok {
+		return
+	}
+
+	m.add(evt.Channel, CategoryUnknownMessage, fmt.Errorf("unknown message %d", id), evt.Frame)
+}
+
+// ProcessInvalidFrame processes a EventFrame that has been found to be invalid after it has been read,
+// i.e. a frame with an invalid checksum.
+func (m *Manager) ProcessInvalidFrame(evt *gomavlib.EventFrame, category Category, err error) {
+	m.add(evt.Channel, category, err, evt.Frame)
+}
+
+// Stats returns the number of errors received since startup,
+// grouped by channel and category.
+func (m *Manager) Stats() []Stats {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	keys := sortedKeys(m.totalCounts)
+	ret := make([]Stats, len(keys))
+
+	for i, key := range keys {
+		ret[i] = Stats{
+			Channel:  key.channel,
+			Category: key.category,
+			Count:    m.totalCounts[key],
+		}
+	}
+
+	return ret
 }
@@ -3,11 +3,16 @@ package errorman
 import (
 	"context"
 	"fmt"
+	"io"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/bluenviron/gomavlib/v4"
+	"github.com/bluenviron/gomavlib/v4/pkg/dialect"
+	"github.com/bluenviron/gomavlib/v4/pkg/dialects/common"
+	"github.com/bluenviron/gomavlib/v4/pkg/frame"
+	"github.com/bluenviron/gomavlib/v4/pkg/message"
 	"github.com/stretchr/testify/require"
 )
 
@@ -55,4 +60,130 @@ func TestManager(t *testing.T) {
 		cancel()
 		wg.Wait()
 	})
+
+	t.Run("stats", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		var wg sync.WaitGroup
+
+		m := &Manager{
+			Ctx:           ctx,
+			Wg:            &wg,
+			SummaryPeriod: 50 * time.Millisecond,
+			Dialect:       &dialect.Dialect{Version: 3, Messages: []message.Message{&common.MessageHeartbeat{}}},
+		}
+		err := m.Initialize()
+		require.NoError(t, err)
+
+		ch := &gomavlib.Channel{}
+
+		for _, e := range []error{
+			fmt.Errorf("wrong checksum, expected 1234, got 5678, message id is 12"),
+			fmt.Errorf("wrong checksum, expected 1234, got 5678, message id is 13"),
+			fmt.Errorf("invalid magic byte: fd"),
+		} {
+			m.ProcessError(&gomavlib.EventParseError{
+				Error:   e,
+				Channel: ch,
+			})
+		}
+
+		for _, msg := range []message.Message{
+			&common.MessageHeartbeat{},
+			&message.MessageRaw{ID: 0, Payload: []byte{1, 2}},
+			&message.MessageRaw{ID: 12915, Payload: []byte{1, 2}},
+		} {
+			m.ProcessFrame(&gomavlib.EventFrame{
+				Frame:   &frame.V2Frame{Message: msg},
+				Channel: ch,
+			})
+		}
+
+		time.Sleep(100 * time.Millisecond)
+
+		require.Equal(t, []Stats{
+			{
+				Channel:  ch.String(),
+				Category: CategoryInvalidMagic,
+				Count:    1,
+			},
+			{
+				Channel:  ch.String(),
+				Category: CategoryBadCRC,
+				Count:    2,
+			},
+			{
+				Channel:  ch.String(),
+				Category: CategoryUnknownMessage,
+				Count:    1,
+			},
+		}, m.Stats())
+
+		cancel()
+		wg.Wait()
+	})
+}
+
+func TestCategorize(t *testing.T) {
+	for _, ca := range []struct {
+		err      error
+		category Category
+	}{
+		{fmt.Errorf("invalid magic byte: 12"), CategoryInvalidMagic},
+		{fmt.Errorf("wrong checksum, expected 1234, got 5678, message id is 12"), CategoryBadCRC},
+		{fmt.Errorf("signature link id not found"), CategorySignature},
+		{fmt.Errorf("read: %w", io.ErrUnexpectedEOF), CategoryTruncatedFrame},
+		{fmt.Errorf("testing"), CategoryOther},
+	} {
+		t.Run(ca.category.String(), func(t *testing.T) {
+			require.Equal(t, ca.category, categorize(ca.err))
+		})
+	}
+}
+
+func TestFrameBytes(t *testing.T) {
+	for _, ca := range []struct {
+		name string
+		fr   frame.Frame
+		byts []byte
+	}{
+		{
+			"v1",
+			&frame.V1Frame{
+				SequenceNumber: 1,
+				SystemID:       2,
+				ComponentID:    3,
+				Message:        &message.MessageRaw{ID: 4, Payload: []byte{5, 6}},
+				Checksum:       0x0807,
+			},
+			[]byte{0xfe, 2, 1, 2, 3, 4, 5, 6, 7, 8},
+		},
+		{
+			"v2 signed",
+			&frame.V2Frame{
+				IncompatibilityFlag: 1,
+				SequenceNumber:      1,
+				SystemID:            2,
+				ComponentID:         3,
+				Message:             &message.MessageRaw{ID: 0x030201, Payload: []byte{5}},
+				Checksum:            0x0807,
+				SignatureLinkID:     9,
+				SignatureTimestamp:  0x0f0e0d0c0b0a,
+				Signature:           &frame.V2Signature{0x10, 0x11, 0x12, 0x13, 0x14, 0x15},
+			},
+			[]byte{
+				0xfd, 1, 1, 0, 1, 2, 3, 1, 2, 3, 5, 7, 8,
+				9, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10, 0x11, 0x12, 0x13, 0x14, 0x15,
+			},
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			byts, ok := frameBytes(ca.fr)
+			require.True(t, ok)
+			require.Equal(t, ca.byts, byts)
+		})
+	}
+
+	// decoded messages cannot be dumped
+	_, ok := frameBytes(&frame.V2Frame{Message: &common.MessageHeartbeat{}})
+	require.False(t, ok)
 }
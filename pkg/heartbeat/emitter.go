@@ -0,0 +1,190 @@
+// Package heartbeat contains the heartbeat emitter.
+package heartbeat
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gomavlib/v4"
+	"github.com/bluenviron/gomavlib/v4/pkg/dialects/common"
+	"github.com/bluenviron/gomavlib/v4/pkg/message"
+
+	"github.com/bluenviron/mavp2p/pkg/logger"
+	"github.com/bluenviron/mavp2p/pkg/messageman"
+)
+
+// a router is considered gone when its heartbeats
+// are not received for this number of periods.
+const routerTimeoutPeriods = 3
+
+const defaultPeriod = 5 * time.Second
+
+var (
+	timeNow         = time.Now
+	channelEndpoint = func(ch *gomavlib.Channel) gomavlib.Endpoint { return ch.Endpoint() }
+	writeMessageTo  = func(m *messageman.Manager, ch *gomavlib.Channel, msg message.Message) error {
+		return m.WriteMessageTo(ch, msg)
+	}
+)
+
+type channelState struct {
+	routerSeen time.Time
+	yielding   bool
+}
+
+// Emitter is a heartbeat emitter.
+// It sends heartbeats to each channel, except to the ones of disabled endpoints.
+type Emitter struct {
+	Ctx          context.Context
+	Wg           *sync.WaitGroup
+	MessageMan   *messageman.Manager
+	Period       time.Duration // defaults to 5 seconds
+	SystemID     byte
+	ComponentID  byte
+	Type         common.MAV_TYPE
+	Autopilot    common.MAV_AUTOPILOT
+	SystemStatus common.MAV_STATE
+
+	// heartbeats are not sent to these endpoints.
+	DisabledEndpoints []gomavlib.Endpoint
+
+	// stop sending heartbeats to a channel when another router is present on it,
+	// i.e. when a heartbeat with the same type and component ID but a lower system ID is received.
+	// Only the router with the higher system ID yields, otherwise two routers
+	// on the same link would stop and resume in turn.
+	YieldToRouters bool
+
+	Log *slog.Logger
+
+	disabled map[gomavlib.Endpoint]struct{}
+
+	mutex    sync.Mutex
+	channels map[*gomavlib.Channel]*channelState
+}
+
+// Initialize initializes an Emitter.
+func (e *Emitter) Initialize() error {
+	if e.Log == nil {
+		e.Log = slog.Default()
+	}
+
+	if e.Period <= 0 {
+		e.Period = defaultPeriod
+	}
+
+	e.disabled = make(map[gomavlib.Endpoint]struct{})
+	for _, ep := range e.DisabledEndpoints {
+		e.disabled[ep] = struct{}{}
+	}
+
+	e.channels = make(map[*gomavlib.Channel]*channelState)
+
+	e.Wg.Add(1)
+	go e.run()
+
+	return nil
+}
+
+func (e *Emitter) run() {
+	defer e.Wg.Done()
+
+	ticker := time.NewTicker(e.Period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			e.emit()
+
+		case <-e.Ctx.Done():
+			return
+		}
+	}
+}
+
+func (e *Emitter) targets() []*gomavlib.Channel {
+	now := timeNow()
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	var targets []*gomavlib.Channel
+
+	for ch, state := range e.channels {
+		if state.yielding {
+			if now.Sub(state.routerSeen) < routerTimeoutPeriods*e.Period {
+				continue
+			}
+
+			state.yielding = false
+			e.Log.Info("router is gone, resuming heartbeats", logger.Channel(ch))
+		}
+
+		targets = append(targets, ch)
+	}
+
+	return targets
+}
+
+func (e *Emitter) emit() {
+	msg := &common.MessageHeartbeat{
+		Type:           e.Type,
+		Autopilot:      e.Autopilot,
+		SystemStatus:   e.SystemStatus,
+		MavlinkVersion: 3,
+	}
+
+	for _, ch := range e.targets() {
+		writeMessageTo(e.MessageMan, ch, msg) //nolint:errcheck
+	}
+}
+
+// ProcessChannelOpen processes a EventChannelOpen.
+func (e *Emitter) ProcessChannelOpen(evt *gomavlib.EventChannelOpen) {
+	if _, ok := e.disabled[channelEndpoint(evt.Channel)]; ok {
+		return
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.channels[evt.Channel] = &channelState{}
+}
+
+// ProcessChannelClose processes a EventChannelClose.
+func (e *Emitter) ProcessChannelClose(evt *gomavlib.EventChannelClose) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	delete(e.channels, evt.Channel)
+}
+
+// ProcessFrame processes a EventFrame.
+func (e *Emitter) ProcessFrame(evt *gomavlib.EventFrame) {
+	if !e.YieldToRouters {
+		return
+	}
+
+	hb, ok := evt.Message().(*common.MessageHeartbeat)
+	if !ok || hb.Type != e.Type || evt.ComponentID() != e.ComponentID || evt.SystemID() >= e.SystemID {
+		return
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	state, ok := e.channels[evt.Channel]
+	if !ok {
+		return
+	}
+
+	state.routerSeen = timeNow()
+
+	if !state.yielding {
+		state.yielding = true
+		e.Log.Info("another router is present, stopping heartbeats",
+			logger.Channel(evt.Channel), logger.SystemID(evt.SystemID()))
+	}
+}
@@ -0,0 +1,189 @@
+package heartbeat
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gomavlib/v4"
+	"github.com/bluenviron/gomavlib/v4/pkg/dialects/common"
+	"github.com/bluenviron/gomavlib/v4/pkg/frame"
+	"github.com/bluenviron/gomavlib/v4/pkg/message"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bluenviron/mavp2p/pkg/messageman"
+)
+
+func TestEmitter(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	serial := &gomavlib.EndpointSerial{}
+	gcs := &gomavlib.EndpointUDPServer{}
+	other := &gomavlib.EndpointUDPServer{}
+	serialCh := &gomavlib.Channel{}
+	gcsCh := &gomavlib.Channel{}
+	otherCh := &gomavlib.Channel{}
+
+	channelEndpoints := map[*gomavlib.Channel]gomavlib.Endpoint{
+		serialCh: serial,
+		gcsCh:    gcs,
+		otherCh:  other,
+	}
+	channelEndpoint = func(ch *gomavlib.Channel) gomavlib.Endpoint {
+		return channelEndpoints[ch]
+	}
+	defer func() { channelEndpoint = func(ch *gomavlib.Channel) gomavlib.Endpoint { return ch.Endpoint() } }()
+
+	var written []*gomavlib.Channel
+	writeMessageTo = func(_ *messageman.Manager, ch *gomavlib.Channel, msg message.Message) error {
+		require.Equal(t, &common.MessageHeartbeat{
+			Type:           common.MAV_TYPE_GCS,
+			Autopilot:      common.MAV_AUTOPILOT_INVALID,
+			SystemStatus:   common.MAV_STATE_ACTIVE,
+			MavlinkVersion: 3,
+		}, msg)
+		written = append(written, ch)
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	e := &Emitter{
+		Ctx:               ctx,
+		Wg:                &wg,
+		Period:            time.Hour,
+		SystemID:          125,
+		ComponentID:       191,
+		Type:              common.MAV_TYPE_GCS,
+		Autopilot:         common.MAV_AUTOPILOT_INVALID,
+		SystemStatus:      common.MAV_STATE_ACTIVE,
+		DisabledEndpoints: []gomavlib.Endpoint{serial},
+		YieldToRouters:    true,
+	}
+	err := e.Initialize()
+	require.NoError(t, err)
+
+	for _, ch := range []*gomavlib.Channel{serialCh, gcsCh, otherCh} {
+		e.ProcessChannelOpen(&gomavlib.EventChannelOpen{Channel: ch})
+	}
+
+	// a router with a higher system ID is ignored
+	e.ProcessFrame(&gomavlib.EventFrame{
+		Frame: &frame.V2Frame{
+			SystemID:    126,
+			ComponentID: 191,
+			Message:     &common.MessageHeartbeat{Type: common.MAV_TYPE_GCS},
+		},
+		Channel: otherCh,
+	})
+
+	e.emit()
+	require.ElementsMatch(t, []*gomavlib.Channel{gcsCh, otherCh}, written)
+
+	// another router is present on otherCh
+	written = nil
+	e.ProcessFrame(&gomavlib.EventFrame{
+		Frame: &frame.V2Frame{
+			SystemID:    124,
+			ComponentID: 191,
+			Message:     &common.MessageHeartbeat{Type: common.MAV_TYPE_GCS},
+		},
+		Channel: otherCh,
+	})
+
+	e.emit()
+	require.Equal(t, []*gomavlib.Channel{gcsCh}, written)
+
+	// the router is gone
+	written = nil
+	now = now.Add(3 * time.Hour)
+	e.emit()
+	require.ElementsMatch(t, []*gomavlib.Channel{gcsCh, otherCh}, written)
+
+	e.ProcessChannelClose(&gomavlib.EventChannelClose{Channel: gcsCh})
+	written = nil
+	e.emit()
+	require.Equal(t, []*gomavlib.Channel{otherCh}, written)
+
+	cancel()
+	wg.Wait()
+}
+
+func TestEmitterYieldTieBreak(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	channelEndpoint = func(_ *gomavlib.Channel) gomavlib.Endpoint { return &gomavlib.EndpointUDPServer{} }
+	defer func() { channelEndpoint = func(ch *gomavlib.Channel) gomavlib.Endpoint { return ch.Endpoint() } }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	newEmitter := func(systemID byte) *Emitter {
+		e := &Emitter{
+			Ctx:            ctx,
+			Wg:             &wg,
+			Period:         time.Hour,
+			SystemID:       systemID,
+			ComponentID:    191,
+			Type:           common.MAV_TYPE_GCS,
+			YieldToRouters: true,
+		}
+		err := e.Initialize()
+		require.NoError(t, err)
+		return e
+	}
+
+	// two routers on the same link, each one receiving the heartbeats of the other
+	low := newEmitter(125)
+	high := newEmitter(126)
+	lowCh := &gomavlib.Channel{}
+	highCh := &gomavlib.Channel{}
+	low.ProcessChannelOpen(&gomavlib.EventChannelOpen{Channel: lowCh})
+	high.ProcessChannelOpen(&gomavlib.EventChannelOpen{Channel: highCh})
+
+	var written []byte
+	writeMessageTo = func(_ *messageman.Manager, ch *gomavlib.Channel, msg message.Message) error {
+		sender, receiver, receiverCh := low, high, highCh
+		if ch == highCh {
+			sender, receiver, receiverCh = high, low, lowCh
+		}
+
+		written = append(written, sender.SystemID)
+		receiver.ProcessFrame(&gomavlib.EventFrame{
+			Frame: &frame.V2Frame{
+				SystemID:    sender.SystemID,
+				ComponentID: sender.ComponentID,
+				Message:     msg,
+			},
+			Channel: receiverCh,
+		})
+		return nil
+	}
+	defer func() {
+		writeMessageTo = func(m *messageman.Manager, ch *gomavlib.Channel, msg message.Message) error {
+			return m.WriteMessageTo(ch, msg)
+		}
+	}()
+
+	high.emit()
+	low.emit()
+	require.Equal(t, []byte{126, 125}, written)
+
+	// only the router with the higher system ID yields, and it does not resume
+	for i := 0; i < 5; i++ {
+		written = nil
+		now = now.Add(time.Hour)
+		high.emit()
+		low.emit()
+		require.Equal(t, []byte{125}, written)
+	}
+
+	cancel()
+	wg.Wait()
+}
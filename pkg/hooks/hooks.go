@@ -0,0 +1,260 @@
+// Package hooks contains the hook runner.
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	envPrefix            = "MAVP2P_"
+	defaultTimeout       = 10 * time.Second
+	defaultMaxConcurrent = 4
+)
+
+var timeNow = time.Now
+
+// event types.
+const (
+	EventChannelOpened   = "channel_opened"
+	EventChannelClosed   = "channel_closed"
+	EventNodeAppeared    = "node_appeared"
+	EventNodeDisappeared = "node_disappeared"
+	EventParseErrors     = "parse_errors"
+	EventBondSwitched    = "bond_switched"
+
+	// component events are named after registry events,
+	// i.e. component_armed.
+	EventComponentPrefix = "component_"
+)
+
+// EventTypes are all the event types.
+var EventTypes = []string{
+	EventChannelOpened,
+	EventChannelClosed,
+	EventNodeAppeared,
+	EventNodeDisappeared,
+	EventParseErrors,
+	EventBondSwitched,
+	EventComponentPrefix + "appeared",
+	EventComponentPrefix + "disappeared",
+	EventComponentPrefix + "armed",
+	EventComponentPrefix + "disarmed",
+	EventComponentPrefix + "mode_changed",
+	EventComponentPrefix + "status_changed",
+	EventComponentPrefix + "version_received",
+}
+
+// Event is a router event.
+type Event struct {
+	Type   string
+	Fields map[string]string
+}
+
+// Hooks runs a command or sends a HTTP request when router events occur.
+type Hooks struct {
+	Ctx context.Context
+	Wg  *sync.WaitGroup
+
+	// shell command to run. Event details are passed in environment variables
+	// (MAVP2P_EVENT, MAVP2P_TIME, MAVP2P_CHANNEL, ...).
+	Command string
+
+	// URL that receives event details in a JSON POST request.
+	URL string
+
+	// if not empty, react only to these events.
+	Events []string
+
+	// timeout of commands and requests.
+	// It defaults to 10 seconds.
+	Timeout time.Duration
+
+	// maximum number of commands and requests running at the same time.
+	// Events that occur when the limit is reached are discarded.
+	// It defaults to 4.
+	MaxConcurrent int
+
+	Log *slog.Logger
+
+	events     map[string]struct{}
+	semaphore  chan struct{}
+	httpClient *http.Client
+}
+
+// Initialize initializes Hooks.
+func (h *Hooks) Initialize() error {
+	if h.Log == nil {
+		h.Log = slog.Default()
+	}
+
+	if h.Timeout == 0 {
+		h.Timeout = defaultTimeout
+	}
+
+	if h.MaxConcurrent == 0 {
+		h.MaxConcurrent = defaultMaxConcurrent
+	}
+
+	if len(h.Events) != 0 {
+		h.events = make(map[string]struct{})
+		for _, typ := range h.Events {
+			if !isEventType(typ) {
+				return fmt.Errorf("invalid hook event: %s", typ)
+			}
+			h.events[typ] = struct{}{}
+		}
+	}
+
+	h.semaphore = make(chan struct{}, h.MaxConcurrent)
+	h.httpClient = &http.Client{Timeout: h.Timeout}
+
+	return nil
+}
+
+func isEventType(typ string) bool {
+	for _, t := range EventTypes {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// Fire runs hooks associated with an event, without waiting for them to complete.
+func (h *Hooks) Fire(evt Event) {
+	if h.Ctx.Err() != nil {
+		return
+	}
+
+	if h.events != nil {
+		if _, ok := h.events[evt.Type]; !ok {
+			return
+		}
+	}
+
+	select {
+	case h.semaphore <- struct{}{}:
+	default:
+		h.Log.Warn("too many hooks are running, discarding event", slog.String("event", evt.Type))
+		return
+	}
+
+	t := timeNow()
+
+	h.Wg.Add(1)
+	go func() {
+		defer h.Wg.Done()
+		defer func() { <-h.semaphore }()
+
+		ctx, ctxCancel := context.WithTimeout(h.Ctx, h.Timeout)
+		defer ctxCancel()
+
+		if h.Command != "" {
+			err := h.runCommand(ctx, evt, t)
+			if err != nil {
+				h.Log.Warn("hook command failed", slog.String("event", evt.Type), slog.Any("error", err))
+			}
+		}
+
+		if h.URL != "" {
+			err := h.post(ctx, evt, t)
+			if err != nil {
+				h.Log.Warn("hook request failed", slog.String("event", evt.Type), slog.Any("error", err))
+			}
+		}
+	}()
+}
+
+func sortedFieldKeys(fields map[string]string) []string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func environment(evt Event, t time.Time) []string {
+	env := []string{
+		envPrefix + "EVENT=" + evt.Type,
+		envPrefix + "TIME=" + t.Format(time.RFC3339Nano),
+	}
+
+	for _, key := range sortedFieldKeys(evt.Fields) {
+		env = append(env, envPrefix+strings.ToUpper(key)+"="+evt.Fields[key])
+	}
+
+	return env
+}
+
+func (h *Hooks) runCommand(ctx context.Context, evt Event, t time.Time) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", h.Command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", h.Command)
+	}
+
+	cmd.Env = append(os.Environ(), environment(evt, t)...)
+
+	// output is logged instead of being written to the standard output,
+	// in order not to corrupt the terminal interface.
+	out, err := cmd.CombinedOutput()
+
+	for line := range strings.Lines(string(out)) {
+		line = strings.TrimRight(line, "\r\n")
+		if line != "" {
+			h.Log.Info("hook output", slog.String("event", evt.Type), slog.String("line", line))
+		}
+	}
+
+	return err
+}
+
+func payload(evt Event, t time.Time) ([]byte, error) {
+	body := make(map[string]string, len(evt.Fields)+2)
+	for key, val := range evt.Fields {
+		body[key] = val
+	}
+	body["event"] = evt.Type
+	body["time"] = t.Format(time.RFC3339Nano)
+
+	return json.Marshal(body)
+}
+
+func (h *Hooks) post(ctx context.Context, evt Event, t time.Time) error {
+	byts, err := payload(evt, t)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(byts))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := h.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("bad status code: %d", res.StatusCode)
+	}
+
+	return nil
+}
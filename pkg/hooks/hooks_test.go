@@ -0,0 +1,165 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+
+	out := filepath.Join(t.TempDir(), "out")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	h := &Hooks{
+		Ctx:     ctx,
+		Wg:      &wg,
+		Command: "echo \"$MAVP2P_EVENT $MAVP2P_CHANNEL $MAVP2P_SYSID\" > " + out,
+		Events:  []string{EventNodeAppeared},
+	}
+	err := h.Initialize()
+	require.NoError(t, err)
+
+	h.Fire(Event{Type: EventNodeDisappeared})
+	h.Fire(Event{
+		Type: EventNodeAppeared,
+		Fields: map[string]string{
+			"channel": "tcp:1.2.3.4:5600",
+			"sysid":   "1",
+		},
+	})
+
+	require.Eventually(t, func() bool {
+		byts, err := os.ReadFile(out)
+		return err == nil && string(byts) == "node_appeared tcp:1.2.3.4:5600 1\n"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	wg.Wait()
+}
+
+type syncBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.String()
+}
+
+func TestCommandOutput(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+
+	var buf syncBuffer
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	h := &Hooks{
+		Ctx:     ctx,
+		Wg:      &wg,
+		Command: "echo first; echo second >&2",
+		Events:  []string{EventNodeAppeared},
+		Log:     slog.New(slog.NewTextHandler(&buf, nil)),
+	}
+	err := h.Initialize()
+	require.NoError(t, err)
+
+	h.Fire(Event{Type: EventNodeAppeared})
+
+	require.Eventually(t, func() bool {
+		s := buf.String()
+		return strings.Contains(s, "event=node_appeared line=first") &&
+			strings.Contains(s, "event=node_appeared line=second")
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	wg.Wait()
+}
+
+func TestURL(t *testing.T) {
+	received := make(chan map[string]string, 1)
+	release := make(chan struct{})
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+		var body map[string]string
+		err := json.NewDecoder(r.Body).Decode(&body)
+		require.NoError(t, err)
+		received <- body
+
+		<-release
+	}))
+	defer s.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	h := &Hooks{
+		Ctx:           ctx,
+		Wg:            &wg,
+		URL:           s.URL,
+		Timeout:       5 * time.Second,
+		MaxConcurrent: 1,
+	}
+	err := h.Initialize()
+	require.NoError(t, err)
+
+	h.Fire(Event{Type: EventChannelOpened, Fields: map[string]string{"channel": "udp:0.0.0.0:5600"}})
+
+	body := <-received
+	require.Equal(t, "channel_opened", body["event"])
+	require.Equal(t, "udp:0.0.0.0:5600", body["channel"])
+	require.NotEmpty(t, body["time"])
+
+	// the limit of concurrent hooks is reached
+	h.Fire(Event{Type: EventChannelClosed})
+
+	close(release)
+
+	require.Eventually(t, func() bool {
+		return len(h.semaphore) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	wg.Wait()
+
+	require.Empty(t, received)
+}
+
+func TestInvalidEvent(t *testing.T) {
+	h := &Hooks{
+		Events: []string{"node_appeared", "foo"},
+	}
+	err := h.Initialize()
+	require.EqualError(t, err, "invalid hook event: foo")
+}
@@ -0,0 +1,347 @@
+// Package linkstats contains the link statistics tracker.
+package linkstats
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gomavlib/v4"
+	"github.com/bluenviron/gomavlib/v4/pkg/dialects/common"
+
+	"github.com/bluenviron/mavp2p/pkg/logger"
+)
+
+var timeNow = time.Now
+
+type linkKey struct {
+	channel     *gomavlib.Channel
+	systemID    byte
+	componentID byte
+}
+
+type counters struct {
+	received   uint64
+	lost       uint64
+	outOfOrder uint64
+	duplicates uint64
+}
+
+func (c *counters) lossRate() float64 {
+	total := c.received + c.lost
+	if total == 0 {
+		return 0
+	}
+	return float64(c.lost) / float64(total)
+}
+
+// RadioStatus is the content of the last RADIO_STATUS sent by a radio.
+type RadioStatus struct {
+	RSSI        uint8
+	RemoteRSSI  uint8
+	TxBuf       uint8
+	Noise       uint8
+	RemoteNoise uint8
+	RxErrors    uint16
+	Fixed       uint16
+}
+
+type link struct {
+	radio          *RadioStatus
+	total          counters
+	period         counters
+	lastSequence   byte
+	received       [256]bool
+	lastArrival    time.Time
+	lastInterval   time.Duration
+	jitter         float64
+	hasSequence    bool
+	hasInterval    bool
+	hasLastArrival bool
+}
+
+func (l *link) processSequence(seq byte) {
+	if !l.hasSequence {
+		l.hasSequence = true
+		l.lastSequence = seq
+		l.received[seq] = true
+		l.total.received++
+		l.period.received++
+		return
+	}
+
+	// sequence numbers wrap around, therefore differences are computed modulo 256.
+	// differences greater than half of the range are considered frames
+	// that are older than the last one.
+	diff := seq - l.lastSequence - 1
+
+	if diff < 128 {
+		for i := l.lastSequence + 1; i != seq; i++ {
+			l.received[i] = false
+		}
+		l.total.lost += uint64(diff)
+		l.period.lost += uint64(diff)
+		l.lastSequence = seq
+	} else {
+		if l.received[seq] {
+			l.total.duplicates++
+			l.period.duplicates++
+			return
+		}
+
+		// the frame was counted as lost
+		l.total.outOfOrder++
+		l.period.outOfOrder++
+		if l.total.lost > 0 {
+			l.total.lost--
+		}
+		if l.period.lost > 0 {
+			l.period.lost--
+		}
+	}
+
+	l.received[seq] = true
+	l.total.received++
+	l.period.received++
+}
+
+// inter-arrival jitter, computed as in RFC 3550.
+func (l *link) processArrival(now time.Time) {
+	if l.hasLastArrival {
+		interval := now.Sub(l.lastArrival)
+
+		if l.hasInterval {
+			d := float64(interval - l.lastInterval)
+			if d < 0 {
+				d = -d
+			}
+			l.jitter += (d - l.jitter) / 16
+		}
+
+		l.lastInterval = interval
+		l.hasInterval = true
+	}
+
+	l.lastArrival = now
+	l.hasLastArrival = true
+}
+
+// Stats are the statistics of a link,
+// identified by a channel and by the system ID and component ID of the sender.
+type Stats struct {
+	Channel     string
+	SystemID    byte
+	ComponentID byte
+	Received    uint64
+	Lost        uint64
+	OutOfOrder  uint64
+	Duplicates  uint64
+	LossRate    float64
+	Jitter      time.Duration
+
+	// available when the sender is a radio.
+	Radio *RadioStatus
+}
+
+// Tracker is a link statistics tracker.
+// It computes packet loss and link quality from sequence numbers.
+type Tracker struct {
+	Ctx         context.Context
+	Wg          *sync.WaitGroup
+	Print       bool
+	PrintPeriod time.Duration
+	Log         *slog.Logger
+
+	mutex sync.Mutex
+	links map[linkKey]*link
+}
+
+// Initialize initializes a Tracker.
+func (t *Tracker) Initialize() error {
+	if t.Log == nil {
+		t.Log = slog.Default()
+	}
+
+	t.links = make(map[linkKey]*link)
+
+	t.Wg.Add(1)
+	go t.run()
+
+	return nil
+}
+
+func (t *Tracker) run() {
+	defer t.Wg.Done()
+
+	if !t.Print {
+		return
+	}
+
+	ticker := time.NewTicker(t.PrintPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			t.printStats()
+
+		case <-t.Ctx.Done():
+			return
+		}
+	}
+}
+
+func (t *Tracker) printStats() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for _, key := range t.sortedKeys() {
+		l := t.links[key]
+		if l.period.received == 0 {
+			continue
+		}
+
+		attrs := []any{
+			logger.Channel(key.channel),
+			logger.SystemID(key.systemID),
+			logger.ComponentID(key.componentID),
+			slog.Uint64("received", l.period.received),
+			slog.Uint64("lost", l.period.lost),
+			slog.Float64("loss_rate", l.period.lossRate()),
+			slog.Uint64("out_of_order", l.period.outOfOrder),
+			slog.Uint64("duplicates", l.period.duplicates),
+			slog.Duration("jitter", time.Duration(l.jitter).Truncate(time.Microsecond)),
+		}
+
+		if l.radio != nil {
+			attrs = append(attrs,
+				slog.Int("rssi", int(l.radio.RSSI)),
+				slog.Int("remote_rssi", int(l.radio.RemoteRSSI)),
+				slog.Int("noise", int(l.radio.Noise)),
+				slog.Int("remote_noise", int(l.radio.RemoteNoise)),
+				slog.Int("txbuf", int(l.radio.TxBuf)),
+				slog.Int("rx_errors", int(l.radio.RxErrors)),
+				slog.Int("fixed", int(l.radio.Fixed)))
+		}
+
+		attrs = append(attrs, slog.Duration("period", t.PrintPeriod))
+
+		t.Log.Info("link stats", attrs...)
+
+		l.period = counters{}
+	}
+}
+
+func (t *Tracker) sortedKeys() []linkKey {
+	keys := make([]linkKey, 0, len(t.links))
+	for key := range t.links {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].channel != keys[j].channel {
+			return keys[i].channel.String() < keys[j].channel.String()
+		}
+		if keys[i].systemID != keys[j].systemID {
+			return keys[i].systemID < keys[j].systemID
+		}
+		return keys[i].componentID < keys[j].componentID
+	})
+
+	return keys
+}
+
+// ProcessFrame processes a EventFrame.
+func (t *Tracker) ProcessFrame(evt *gomavlib.EventFrame) {
+	key := linkKey{
+		channel:     evt.Channel,
+		systemID:    evt.SystemID(),
+		componentID: evt.ComponentID(),
+	}
+	now := timeNow()
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	l, ok := t.links[key]
+	if !ok {
+		l = &link{}
+		t.links[key] = l
+	}
+
+	l.processSequence(evt.Frame.GetSequenceNumber())
+	l.processArrival(now)
+
+	if msg, ok := evt.Message().(*common.MessageRadioStatus); ok {
+		l.radio = &RadioStatus{
+			RSSI:        msg.Rssi,
+			RemoteRSSI:  msg.Remrssi,
+			TxBuf:       msg.Txbuf,
+			Noise:       msg.Noise,
+			RemoteNoise: msg.Remnoise,
+			RxErrors:    msg.Rxerrors,
+			Fixed:       msg.Fixed,
+		}
+	}
+}
+
+// ProcessChannelClose processes a EventChannelClose.
+func (t *Tracker) ProcessChannelClose(evt *gomavlib.EventChannelClose) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for key := range t.links {
+		if key.channel == evt.Channel {
+			delete(t.links, key)
+		}
+	}
+}
+
+// ChannelCounters returns the number of frames received and lost by links of a channel since they appeared.
+func (t *Tracker) ChannelCounters(ch *gomavlib.Channel) (uint64, uint64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var received, lost uint64
+	for key, l := range t.links {
+		if key.channel == ch {
+			received += l.total.received
+			lost += l.total.lost
+		}
+	}
+
+	return received, lost
+}
+
+// Stats returns the statistics of all links since they appeared.
+func (t *Tracker) Stats() []Stats {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	ret := make([]Stats, 0, len(t.links))
+	for _, key := range t.sortedKeys() {
+		l := t.links[key]
+		s := Stats{
+			Channel:     key.channel.String(),
+			SystemID:    key.systemID,
+			ComponentID: key.componentID,
+			Received:    l.total.received,
+			Lost:        l.total.lost,
+			OutOfOrder:  l.total.outOfOrder,
+			Duplicates:  l.total.duplicates,
+			LossRate:    l.total.lossRate(),
+			Jitter:      time.Duration(l.jitter),
+		}
+
+		if l.radio != nil {
+			radio := *l.radio
+			s.Radio = &radio
+		}
+
+		ret = append(ret, s)
+	}
+
+	return ret
+}
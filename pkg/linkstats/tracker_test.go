@@ -0,0 +1,90 @@
+package linkstats_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/bluenviron/gomavlib/v4"
+	"github.com/bluenviron/gomavlib/v4/pkg/dialects/common"
+	"github.com/bluenviron/gomavlib/v4/pkg/frame"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bluenviron/mavp2p/pkg/linkstats"
+)
+
+func TestTracker(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	tr := &linkstats.Tracker{
+		Ctx: ctx,
+		Wg:  &wg,
+	}
+	err := tr.Initialize()
+	require.NoError(t, err)
+
+	ch := &gomavlib.Channel{}
+
+	for _, seq := range []byte{250, 251, 253, 252, 252, 254, 255, 0, 4} {
+		tr.ProcessFrame(&gomavlib.EventFrame{
+			Frame: &frame.V2Frame{
+				SequenceNumber: seq,
+				SystemID:       1,
+				ComponentID:    2,
+				Message:        &common.MessageHeartbeat{},
+			},
+			Channel: ch,
+		})
+	}
+
+	stats := tr.Stats()
+	require.Len(t, stats, 1)
+	require.Equal(t, byte(1), stats[0].SystemID)
+	require.Equal(t, byte(2), stats[0].ComponentID)
+	require.Equal(t, uint64(8), stats[0].Received)
+	require.Equal(t, uint64(3), stats[0].Lost)
+	require.Equal(t, uint64(1), stats[0].OutOfOrder)
+	require.Equal(t, uint64(1), stats[0].Duplicates)
+	require.InDelta(t, 3.0/11.0, stats[0].LossRate, 0.0001)
+	require.Nil(t, stats[0].Radio)
+
+	tr.ProcessFrame(&gomavlib.EventFrame{
+		Frame: &frame.V1Frame{
+			SystemID:    51,
+			ComponentID: 68,
+			Message: &common.MessageRadioStatus{
+				Rssi:     180,
+				Remrssi:  170,
+				Txbuf:    95,
+				Noise:    40,
+				Remnoise: 45,
+				Rxerrors: 2,
+				Fixed:    1,
+			},
+		},
+		Channel: ch,
+	})
+
+	stats = tr.Stats()
+	require.Len(t, stats, 2)
+	require.Equal(t, &linkstats.RadioStatus{
+		RSSI:        180,
+		RemoteRSSI:  170,
+		TxBuf:       95,
+		Noise:       40,
+		RemoteNoise: 45,
+		RxErrors:    2,
+		Fixed:       1,
+	}, stats[1].Radio)
+
+	received, lost := tr.ChannelCounters(ch)
+	require.Equal(t, uint64(9), received)
+	require.Equal(t, uint64(3), lost)
+
+	tr.ProcessChannelClose(&gomavlib.EventChannelClose{Channel: ch})
+	require.Empty(t, tr.Stats())
+
+	cancel()
+	wg.Wait()
+}
@@ -0,0 +1,144 @@
+// Package logger contains the logger.
+package logger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// stable field names.
+const (
+	FieldSubsystem   = "subsystem"
+	FieldChannel     = "channel"
+	FieldSystemID    = "sysid"
+	FieldComponentID = "compid"
+)
+
+// Channel returns an attribute that contains a channel.
+func Channel(ch fmt.Stringer) slog.Attr {
+	return slog.String(FieldChannel, fmt.Sprint(ch))
+}
+
+// SystemID returns an attribute that contains a system ID.
+func SystemID(id byte) slog.Attr {
+	return slog.Int(FieldSystemID, int(id))
+}
+
+// ComponentID returns an attribute that contains a component ID.
+func ComponentID(id byte) slog.Attr {
+	return slog.Int(FieldComponentID, int(id))
+}
+
+// Format is a log format.
+type Format int
+
+// formats.
+const (
+	FormatText Format = iota
+	FormatJSON
+)
+
+// ParseLevel parses a level.
+func ParseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	err := l.UnmarshalText([]byte(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid log level: %s", s)
+	}
+	return l, nil
+}
+
+// ParseSubsystemLevels parses a list of subsystem=level entries.
+func ParseSubsystemLevels(entries []string) (map[string]slog.Level, error) {
+	ret := make(map[string]slog.Level)
+
+	for _, entry := range entries {
+		name, level, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid subsystem level: %s", entry)
+		}
+
+		l, err := ParseLevel(level)
+		if err != nil {
+			return nil, err
+		}
+
+		ret[name] = l
+	}
+
+	return ret, nil
+}
+
+type levelHandler struct {
+	level   slog.Level
+	handler slog.Handler
+}
+
+// Enabled implements slog.Handler.
+func (h *levelHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+// Handle implements slog.Handler.
+func (h *levelHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.handler.Handle(ctx, r)
+}
+
+// WithAttrs implements slog.Handler.
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{
+		level:   h.level,
+		handler: h.handler.WithAttrs(attrs),
+	}
+}
+
+// WithGroup implements slog.Handler.
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{
+		level:   h.level,
+		handler: h.handler.WithGroup(name),
+	}
+}
+
+// Logger is a structured and leveled logger,
+// that allows to override the level of each subsystem.
+type Logger struct {
+	Writer          io.Writer
+	Format          Format
+	Level           slog.Level
+	SubsystemLevels map[string]slog.Level
+
+	handler slog.Handler
+}
+
+// Initialize initializes a Logger.
+func (l *Logger) Initialize() error {
+	// levels are checked by levelHandler
+	opts := &slog.HandlerOptions{Level: slog.Level(-100)}
+
+	switch l.Format {
+	case FormatJSON:
+		l.handler = slog.NewJSONHandler(l.Writer, opts)
+
+	default:
+		l.handler = slog.NewTextHandler(l.Writer, opts)
+	}
+
+	return nil
+}
+
+// Subsystem returns the logger of a subsystem.
+func (l *Logger) Subsystem(name string) *slog.Logger {
+	level, ok := l.SubsystemLevels[name]
+	if !ok {
+		level = l.Level
+	}
+
+	return slog.New(&levelHandler{
+		level:   level,
+		handler: l.handler,
+	}).With(FieldSubsystem, name)
+}
@@ -0,0 +1,68 @@
+package logger_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/bluenviron/mavp2p/pkg/logger"
+)
+
+type testChannel struct{}
+
+func (testChannel) String() string {
+	return "tcp:127.0.0.1:5600"
+}
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+
+	l := &logger.Logger{
+		Writer: &buf,
+		Format: logger.FormatJSON,
+		Level:  slog.LevelInfo,
+		SubsystemLevels: map[string]slog.Level{
+			"messageman": slog.LevelDebug,
+			"dumper":     slog.LevelError,
+		},
+	}
+	err := l.Initialize()
+	require.NoError(t, err)
+
+	l.Subsystem("main").Debug("hidden")
+	l.Subsystem("dumper").Warn("hidden")
+	l.Subsystem("messageman").Debug("node appeared",
+		logger.Channel(testChannel{}), logger.SystemID(1), logger.ComponentID(2))
+
+	var entry map[string]any
+	err = json.Unmarshal(buf.Bytes(), &entry)
+	require.NoError(t, err)
+
+	delete(entry, "time")
+	require.Equal(t, map[string]any{
+		"level":     "DEBUG",
+		"msg":       "node appeared",
+		"subsystem": "messageman",
+		"channel":   "tcp:127.0.0.1:5600",
+		"sysid":     float64(1),
+		"compid":    float64(2),
+	}, entry)
+}
+
+func TestParseSubsystemLevels(t *testing.T) {
+	levels, err := logger.ParseSubsystemLevels([]string{"messageman=debug", "dumper=WARN"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]slog.Level{
+		"messageman": slog.LevelDebug,
+		"dumper":     slog.LevelWarn,
+	}, levels)
+
+	_, err = logger.ParseSubsystemLevels([]string{"messageman"})
+	require.Error(t, err)
+
+	_, err = logger.ParseSubsystemLevels([]string{"messageman=verbose"})
+	require.Error(t, err)
+}
@@ -0,0 +1,142 @@
+// Package loopdetector contains the loop detector.
+package loopdetector
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gomavlib/v4"
+
+	"github.com/bluenviron/mavp2p/pkg/logger"
+)
+
+var (
+	printInterval = 5 * time.Second
+	timeNow       = time.Now
+)
+
+// frames are identified by header fields and checksum.
+// The checksum covers the payload, therefore it is used as payload hash.
+type frameKey struct {
+	systemID    byte
+	componentID byte
+	sequence    byte
+	messageID   uint32
+	checksum    uint16
+}
+
+type frameEntry struct {
+	channel *gomavlib.Channel
+	time    time.Time
+}
+
+type loopKey struct {
+	first  *gomavlib.Channel
+	second *gomavlib.Channel
+}
+
+// Detector is a loop detector.
+// It recognizes frames that are received twice from different channels
+// within a time window.
+type Detector struct {
+	Ctx    context.Context
+	Wg     *sync.WaitGroup
+	Window time.Duration
+	Log    *slog.Logger
+
+	mutex        sync.Mutex
+	frames       map[frameKey]frameEntry
+	loops        map[loopKey]int
+	droppedCount uint64
+}
+
+// Initialize initializes a Detector.
+func (d *Detector) Initialize() error {
+	if d.Log == nil {
+		d.Log = slog.Default()
+	}
+
+	d.frames = make(map[frameKey]frameEntry)
+	d.loops = make(map[loopKey]int)
+
+	d.Wg.Add(1)
+	go d.run()
+
+	return nil
+}
+
+func (d *Detector) run() {
+	defer d.Wg.Done()
+
+	t := time.NewTicker(printInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			func() {
+				now := timeNow()
+
+				d.mutex.Lock()
+				defer d.mutex.Unlock()
+
+				for key, entry := range d.frames {
+					if now.Sub(entry.time) >= d.Window {
+						delete(d.frames, key)
+					}
+				}
+
+				for key, count := range d.loops {
+					d.Log.Warn("loop detected, discarding duplicate frames",
+						logger.Channel(key.second),
+						slog.String("first_channel", fmt.Sprint(key.first)),
+						slog.Int("count", count),
+						slog.Duration("period", printInterval))
+				}
+				clear(d.loops)
+			}()
+
+		case <-d.Ctx.Done():
+			return
+		}
+	}
+}
+
+// ProcessFrame processes a EventFrame.
+// It returns true when the frame is a duplicate and must be discarded.
+func (d *Detector) ProcessFrame(evt *gomavlib.EventFrame) bool {
+	key := frameKey{
+		systemID:    evt.SystemID(),
+		componentID: evt.ComponentID(),
+		sequence:    evt.Frame.GetSequenceNumber(),
+		messageID:   evt.Message().GetID(),
+		checksum:    evt.Frame.GetChecksum(),
+	}
+	now := timeNow()
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	entry, ok := d.frames[key]
+	if ok && now.Sub(entry.time) < d.Window && entry.channel != evt.Channel {
+		d.loops[loopKey{first: entry.channel, second: evt.Channel}]++
+		d.droppedCount++
+		return true
+	}
+
+	d.frames[key] = frameEntry{
+		channel: evt.Channel,
+		time:    now,
+	}
+	return false
+}
+
+// DroppedCount returns the number of duplicate frames discarded since startup.
+func (d *Detector) DroppedCount() uint64 {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.droppedCount
+}
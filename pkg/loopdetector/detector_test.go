@@ -0,0 +1,62 @@
+package loopdetector_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gomavlib/v4"
+	"github.com/bluenviron/gomavlib/v4/pkg/dialects/ardupilotmega"
+	"github.com/bluenviron/gomavlib/v4/pkg/frame"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bluenviron/mavp2p/pkg/loopdetector"
+)
+
+func TestDetector(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	d := &loopdetector.Detector{
+		Ctx:    ctx,
+		Wg:     &wg,
+		Window: 200 * time.Millisecond,
+	}
+	err := d.Initialize()
+	require.NoError(t, err)
+
+	ch1 := &gomavlib.Channel{}
+	ch2 := &gomavlib.Channel{}
+
+	newFrame := func(seq byte) *frame.V2Frame {
+		return &frame.V2Frame{
+			SequenceNumber: seq,
+			SystemID:       1,
+			ComponentID:    1,
+			Message:        &ardupilotmega.MessageHeartbeat{},
+			Checksum:       1234,
+		}
+	}
+
+	require.False(t, d.ProcessFrame(&gomavlib.EventFrame{Frame: newFrame(10), Channel: ch1}))
+
+	// same frame from the same channel
+	require.False(t, d.ProcessFrame(&gomavlib.EventFrame{Frame: newFrame(10), Channel: ch1}))
+
+	// same frame from another channel
+	require.True(t, d.ProcessFrame(&gomavlib.EventFrame{Frame: newFrame(10), Channel: ch2}))
+
+	// different frame from another channel
+	require.False(t, d.ProcessFrame(&gomavlib.EventFrame{Frame: newFrame(11), Channel: ch2}))
+
+	time.Sleep(300 * time.Millisecond)
+
+	// same frame after the window expired
+	require.False(t, d.ProcessFrame(&gomavlib.EventFrame{Frame: newFrame(10), Channel: ch2}))
+
+	require.Equal(t, uint64(1), d.DroppedCount())
+
+	cancel()
+	wg.Wait()
+}
@@ -4,42 +4,78 @@ package messageman
 import (
 	"context"
 	"fmt"
-	"log"
-	"reflect"
+	"log/slog"
+	"sort"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/bluenviron/gomavlib/v4"
+	"github.com/bluenviron/gomavlib/v4/pkg/dialect"
 	"github.com/bluenviron/gomavlib/v4/pkg/dialects/common"
+	"github.com/bluenviron/gomavlib/v4/pkg/frame"
 	"github.com/bluenviron/gomavlib/v4/pkg/message"
+
+	"github.com/bluenviron/mavp2p/pkg/bonder"
+	"github.com/bluenviron/mavp2p/pkg/decimator"
+	"github.com/bluenviron/mavp2p/pkg/hooks"
+	"github.com/bluenviron/mavp2p/pkg/logger"
+	"github.com/bluenviron/mavp2p/pkg/registry"
+	"github.com/bluenviron/mavp2p/pkg/shaper"
+	"github.com/bluenviron/mavp2p/pkg/target"
+	"github.com/bluenviron/mavp2p/pkg/writer"
 )
 
 const (
-	nodeInactiveAfter = 30 * time.Second
+	defaultNodeTimeout = 30 * time.Second
+	defaultSweepPeriod = 10 * time.Second
 )
 
-var zero reflect.Value
+var channelEndpoint = func(ch *gomavlib.Channel) gomavlib.Endpoint { return ch.Endpoint() }
+
+type remoteNodeKey struct {
+	channel     *gomavlib.Channel
+	systemID    byte
+	componentID byte
+}
 
-func getTarget(msg message.Message) (byte, byte, bool) {
-	rv := reflect.ValueOf(msg).Elem()
-	ts := rv.FieldByName("TargetSystem")
-	tc := rv.FieldByName("TargetComponent")
+func (i remoteNodeKey) logAttrs() []any {
+	return []any{logger.Channel(i.channel), logger.SystemID(i.systemID), logger.ComponentID(i.componentID)}
+}
 
-	if ts != zero && tc != zero {
-		return byte(ts.Uint()), byte(tc.Uint()), true
+func (i remoteNodeKey) hookFields() map[string]string {
+	return map[string]string{
+		logger.FieldChannel:     fmt.Sprint(i.channel),
+		logger.FieldSystemID:    strconv.FormatUint(uint64(i.systemID), 10),
+		logger.FieldComponentID: strconv.FormatUint(uint64(i.componentID), 10),
 	}
+}
 
-	return 0, 0, false
+type remoteNode struct {
+	lastSeen time.Time
 }
 
-type remoteNodeKey struct {
-	channel     *gomavlib.Channel
-	systemID    byte
-	componentID byte
+// StaticRoute is a route to a remote node that is known in advance.
+type StaticRoute struct {
+	SystemID byte
+
+	// zero means any component.
+	ComponentID byte
+
+	Endpoint gomavlib.Endpoint
+}
+
+func (r StaticRoute) matches(systemID byte, componentID byte) bool {
+	return r.SystemID == systemID &&
+		(r.ComponentID == 0 || componentID == 0 || r.ComponentID == componentID)
 }
 
-func (i remoteNodeKey) String() string {
-	return fmt.Sprintf("chan=%s sid=%d cid=%d", i.channel, i.systemID, i.componentID)
+// NodeStatus is the status of a remote node.
+type NodeStatus struct {
+	Channel     string
+	SystemID    byte
+	ComponentID byte
+	LastSeen    time.Time
 }
 
 // Manager is a message manager.
@@ -48,14 +84,63 @@ type Manager struct {
 	Wg               *sync.WaitGroup
 	StreamReqDisable bool
 	Node             *gomavlib.Node
+	Bonder           *bonder.Bonder
+	Decimator        *decimator.Decimator
+	Shaper           *shaper.Shaper
+	Writer           *writer.Writer
+	Hooks            *hooks.Hooks
+	Log              *slog.Logger
+
+	// decides when remote nodes are inactive,
+	// following node timeouts and sticky vehicles.
+	// When nil, remote nodes are removed after 30 seconds of inactivity.
+	Registry *registry.Registry
+
+	// dialect from which targets of messages are extracted.
+	// Targets of messages that have not been decoded are read from their payload.
+	// It defaults to the common dialect.
+	Dialect *dialect.Dialect
+
+	// period of the check of inactive nodes.
+	// It defaults to 10 seconds.
+	SweepPeriod time.Duration
+
+	// routes that are used when a node has not been seen yet. They never expire.
+	StaticRoutes []StaticRoute
+
+	targets *target.Extractor
 
 	remoteNodeMutex sync.Mutex
-	remoteNodes     map[remoteNodeKey]time.Time
+	remoteNodes     map[remoteNodeKey]*remoteNode
+
+	channelsMutex   sync.Mutex
+	channels        map[*gomavlib.Channel]struct{}
+	sequenceNumbers map[*gomavlib.Channel]byte
 }
 
 // Initialize initializes a Manager.
 func (m *Manager) Initialize() error {
-	m.remoteNodes = make(map[remoteNodeKey]time.Time)
+	if m.Log == nil {
+		m.Log = slog.Default()
+	}
+
+	if m.Dialect == nil {
+		m.Dialect = common.Dialect
+	}
+
+	m.targets = &target.Extractor{Dialect: m.Dialect}
+	err := m.targets.Initialize()
+	if err != nil {
+		return err
+	}
+
+	if m.SweepPeriod == 0 {
+		m.SweepPeriod = defaultSweepPeriod
+	}
+
+	m.remoteNodes = make(map[remoteNodeKey]*remoteNode)
+	m.channels = make(map[*gomavlib.Channel]struct{})
+	m.sequenceNumbers = make(map[*gomavlib.Channel]byte)
 
 	m.Wg.Add(1)
 	go m.run()
@@ -66,23 +151,14 @@ func (m *Manager) Initialize() error {
 func (m *Manager) run() {
 	defer m.Wg.Done()
 
+	ticker := time.NewTicker(m.SweepPeriod)
+	defer ticker.Stop()
+
 	// delete remote nodes after a period of inactivity
 	for {
 		select {
-		case <-time.After(10 * time.Second):
-			func() {
-				now := time.Now()
-
-				m.remoteNodeMutex.Lock()
-				defer m.remoteNodeMutex.Unlock()
-
-				for rnode, t := range m.remoteNodes {
-					if now.Sub(t) >= nodeInactiveAfter {
-						log.Printf("node disappeared: %s", rnode)
-						delete(m.remoteNodes, rnode)
-					}
-				}
-			}()
+		case <-ticker.C:
+			m.sweep()
 
 		case <-m.Ctx.Done():
 			return
@@ -90,6 +166,41 @@ func (m *Manager) run() {
 	}
 }
 
+func (m *Manager) nodeAppeared(key remoteNodeKey) {
+	m.Log.Info("node appeared", key.logAttrs()...)
+
+	if m.Hooks != nil {
+		m.Hooks.Fire(hooks.Event{Type: hooks.EventNodeAppeared, Fields: key.hookFields()})
+	}
+}
+
+func (m *Manager) nodeDisappeared(key remoteNodeKey) {
+	m.Log.Info("node disappeared", key.logAttrs()...)
+
+	if m.Hooks != nil {
+		m.Hooks.Fire(hooks.Event{Type: hooks.EventNodeDisappeared, Fields: key.hookFields()})
+	}
+}
+
+func (m *Manager) expired(rnode remoteNodeKey, n *remoteNode) bool {
+	if m.Registry == nil {
+		return time.Since(n.lastSeen) >= defaultNodeTimeout
+	}
+	return m.Registry.Expired(rnode.channel, rnode.systemID, rnode.componentID, n.lastSeen)
+}
+
+func (m *Manager) sweep() {
+	m.remoteNodeMutex.Lock()
+	defer m.remoteNodeMutex.Unlock()
+
+	for rnode, n := range m.remoteNodes {
+		if m.expired(rnode, n) {
+			m.nodeDisappeared(rnode)
+			delete(m.remoteNodes, rnode)
+		}
+	}
+}
+
 func (m *Manager) findNodeBySystemID(systemID byte) *remoteNodeKey {
 	for key := range m.remoteNodes {
 		if key.systemID == systemID {
@@ -120,11 +231,14 @@ func (m *Manager) ProcessFrame(evt *gomavlib.EventFrame) {
 		m.remoteNodeMutex.Lock()
 		defer m.remoteNodeMutex.Unlock()
 
-		if _, ok := m.remoteNodes[key]; !ok {
-			log.Printf("node appeared: %s", key)
+		n, ok := m.remoteNodes[key]
+		if !ok {
+			m.nodeAppeared(key)
+			n = &remoteNode{}
+			m.remoteNodes[key] = n
 		}
 
-		m.remoteNodes[key] = time.Now()
+		n.lastSeen = time.Now()
 	}()
 
 	// stop stream request messages
@@ -134,36 +248,155 @@ func (m *Manager) ProcessFrame(evt *gomavlib.EventFrame) {
 		}
 	}
 
+	targets := m.route(evt)
+
+	if m.Bonder != nil {
+		targets = m.Bonder.Egress(evt.Channel, targets)
+	}
+
+	if m.Decimator != nil {
+		targets = m.Decimator.Egress(evt.Frame, targets)
+	}
+
+	m.write(evt.Frame, targets)
+}
+
+// write sends a frame to channels through the shaper and the writer.
+func (m *Manager) write(fr frame.Frame, targets []*gomavlib.Channel) {
+	if m.Shaper != nil {
+		targets = m.Shaper.Egress(fr, targets)
+	}
+
+	for _, ch := range targets {
+		m.Writer.Write(ch, fr)
+	}
+}
+
+// WriteFrameTo sends a frame generated by the router to a channel.
+// Like routed frames, it is shaped and converted to the Mavlink version of the channel.
+func (m *Manager) WriteFrameTo(ch *gomavlib.Channel, fr frame.Frame) error {
+	err := m.Node.FixFrame(fr)
+	if err != nil {
+		return err
+	}
+
+	m.write(fr, []*gomavlib.Channel{ch})
+	return nil
+}
+
+// WriteMessageTo sends a message generated by the router to a channel,
+// with the system ID, component ID and Mavlink version of the node.
+func (m *Manager) WriteMessageTo(ch *gomavlib.Channel, msg message.Message) error {
+	m.channelsMutex.Lock()
+	seq := m.sequenceNumbers[ch]
+	m.sequenceNumbers[ch] = seq + 1
+	m.channelsMutex.Unlock()
+
+	var fr frame.Frame
+	if m.Node.OutVersion == gomavlib.V2 {
+		fr = &frame.V2Frame{
+			SequenceNumber: seq,
+			SystemID:       m.Node.OutSystemID,
+			ComponentID:    m.Node.OutComponentID,
+			Message:        msg,
+		}
+	} else {
+		fr = &frame.V1Frame{
+			SequenceNumber: seq,
+			SystemID:       m.Node.OutSystemID,
+			ComponentID:    m.Node.OutComponentID,
+			Message:        msg,
+		}
+	}
+
+	return m.WriteFrameTo(ch, fr)
+}
+
+func (m *Manager) route(evt *gomavlib.EventFrame) []*gomavlib.Channel {
 	// if message has a target, route only to it
-	systemID, componentID, hasTarget := getTarget(evt.Message())
+	systemID, componentID, hasTarget := m.targets.Target(evt.Message())
 	if hasTarget && systemID > 0 {
+		m.remoteNodeMutex.Lock()
 		var key *remoteNodeKey
 		if componentID == 0 {
 			key = m.findNodeBySystemID(systemID)
 		} else {
 			key = m.findNodeBySystemAndComponentID(systemID, componentID)
 		}
+		m.remoteNodeMutex.Unlock()
 
 		if key != nil {
 			if key.channel == evt.Channel {
-				log.Printf("Warning: channel %s attempted to send message to itself, discarding", key.channel)
+				m.Log.Warn("channel attempted to send message to itself, discarding", logger.Channel(key.channel))
 			} else {
-				m.Node.WriteFrameTo(key.channel, evt.Frame) //nolint:errcheck
-				return
+				return []*gomavlib.Channel{key.channel}
 			}
+		} else if targets := m.staticRoute(evt.Channel, systemID, componentID); targets != nil {
+			return targets
 		} else {
-			log.Printf(
-				"Warning: received message addressed to unexistent node with systemID=%d and componentID=%d",
-				systemID, componentID)
+			m.Log.Warn("received message addressed to unexistent node",
+				logger.SystemID(systemID), logger.ComponentID(componentID))
 		}
 	}
 
 	// otherwise, route message to every channel
-	m.Node.WriteFrameExcept(evt.Channel, evt.Frame) //nolint:errcheck
+	m.channelsMutex.Lock()
+	defer m.channelsMutex.Unlock()
+
+	targets := make([]*gomavlib.Channel, 0, len(m.channels))
+	for ch := range m.channels {
+		if ch != evt.Channel {
+			targets = append(targets, ch)
+		}
+	}
+	return targets
+}
+
+// staticRoute returns the open channels of the endpoint of the first static route
+// that matches the target, or nil if there are none.
+func (m *Manager) staticRoute(source *gomavlib.Channel, systemID byte, componentID byte) []*gomavlib.Channel {
+	var endpoint gomavlib.Endpoint
+	for _, r := range m.StaticRoutes {
+		if r.matches(systemID, componentID) {
+			endpoint = r.Endpoint
+			break
+		}
+	}
+
+	if endpoint == nil {
+		return nil
+	}
+
+	m.channelsMutex.Lock()
+	defer m.channelsMutex.Unlock()
+
+	var targets []*gomavlib.Channel
+	for ch := range m.channels {
+		if ch != source && channelEndpoint(ch) == endpoint {
+			targets = append(targets, ch)
+		}
+	}
+	return targets
+}
+
+// ProcessChannelOpen processes a EventChannelOpen.
+func (m *Manager) ProcessChannelOpen(evt *gomavlib.EventChannelOpen) {
+	m.channelsMutex.Lock()
+	defer m.channelsMutex.Unlock()
+
+	m.channels[evt.Channel] = struct{}{}
 }
 
 // ProcessChannelClose processes a EventChannelClose.
 func (m *Manager) ProcessChannelClose(evt *gomavlib.EventChannelClose) {
+	func() {
+		m.channelsMutex.Lock()
+		defer m.channelsMutex.Unlock()
+
+		delete(m.channels, evt.Channel)
+		delete(m.sequenceNumbers, evt.Channel)
+	}()
+
 	m.remoteNodeMutex.Lock()
 	defer m.remoteNodeMutex.Unlock()
 
@@ -171,7 +404,36 @@ func (m *Manager) ProcessChannelClose(evt *gomavlib.EventChannelClose) {
 	for key := range m.remoteNodes {
 		if key.channel == evt.Channel {
 			delete(m.remoteNodes, key)
-			log.Printf("node disappeared: %s", key)
+			m.nodeDisappeared(key)
 		}
 	}
 }
+
+// Nodes returns the status of remote nodes.
+func (m *Manager) Nodes() []NodeStatus {
+	m.remoteNodeMutex.Lock()
+	defer m.remoteNodeMutex.Unlock()
+
+	ret := make([]NodeStatus, 0, len(m.remoteNodes))
+
+	for key, n := range m.remoteNodes {
+		ret = append(ret, NodeStatus{
+			Channel:     fmt.Sprint(key.channel),
+			SystemID:    key.systemID,
+			ComponentID: key.componentID,
+			LastSeen:    n.lastSeen,
+		})
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].SystemID != ret[j].SystemID {
+			return ret[i].SystemID < ret[j].SystemID
+		}
+		if ret[i].ComponentID != ret[j].ComponentID {
+			return ret[i].ComponentID < ret[j].ComponentID
+		}
+		return ret[i].Channel < ret[j].Channel
+	})
+
+	return ret
+}
@@ -4,14 +4,18 @@ import (
 	"context"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/bluenviron/gomavlib/v4"
 	"github.com/bluenviron/gomavlib/v4/pkg/dialects/ardupilotmega"
+	"github.com/bluenviron/gomavlib/v4/pkg/dialects/common"
 	"github.com/bluenviron/gomavlib/v4/pkg/frame"
 	"github.com/bluenviron/gomavlib/v4/pkg/message"
 	"github.com/stretchr/testify/require"
 
 	"github.com/bluenviron/mavp2p/pkg/messageman"
+	"github.com/bluenviron/mavp2p/pkg/registry"
+	"github.com/bluenviron/mavp2p/pkg/writer"
 )
 
 func TestRouteSingle(t *testing.T) {
@@ -33,11 +37,16 @@ func TestRouteSingle(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	var wg sync.WaitGroup
 
+	w := &writer.Writer{Ctx: ctx, Wg: &wg, Node: node}
+	err = w.Initialize()
+	require.NoError(t, err)
+
 	m := &messageman.Manager{
 		Ctx:              ctx,
 		Wg:               &wg,
 		StreamReqDisable: true,
 		Node:             node,
+		Writer:           w,
 	}
 	err = m.Initialize()
 	require.NoError(t, err)
@@ -58,6 +67,7 @@ func TestRouteSingle(t *testing.T) {
 
 	evt := <-node.Events()
 	<-client.Events()
+	w.ProcessChannelOpen(evt.(*gomavlib.EventChannelOpen))
 	ch := evt.(*gomavlib.EventChannelOpen).Channel
 
 	fr := &frame.V2Frame{
@@ -123,11 +133,16 @@ func TestRouteAll(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	var wg sync.WaitGroup
 
+	w := &writer.Writer{Ctx: ctx, Wg: &wg, Node: node}
+	err = w.Initialize()
+	require.NoError(t, err)
+
 	m := &messageman.Manager{
 		Ctx:              ctx,
 		Wg:               &wg,
 		StreamReqDisable: true,
 		Node:             node,
+		Writer:           w,
 	}
 	err = m.Initialize()
 	require.NoError(t, err)
@@ -146,8 +161,10 @@ func TestRouteAll(t *testing.T) {
 	require.NoError(t, err)
 	defer client.Close()
 
-	<-node.Events()
+	evt := <-node.Events()
 	<-client.Events()
+	w.ProcessChannelOpen(evt.(*gomavlib.EventChannelOpen))
+	m.ProcessChannelOpen(evt.(*gomavlib.EventChannelOpen))
 
 	fr := &frame.V2Frame{
 		SequenceNumber: 127,
@@ -165,7 +182,7 @@ func TestRouteAll(t *testing.T) {
 		Frame: fr,
 	})
 
-	evt := <-client.Events()
+	evt = <-client.Events()
 	require.Equal(t, &message.MessageRaw{
 		ID: 11033,
 		Payload: []byte{
@@ -178,3 +195,171 @@ func TestRouteAll(t *testing.T) {
 	cancel()
 	wg.Wait()
 }
+
+func TestRouteStatic(t *testing.T) {
+	staticConf := &gomavlib.EndpointTCPServer{
+		Address: "127.0.0.1:3345",
+	}
+
+	node := &gomavlib.Node{
+		Endpoints: []gomavlib.Endpoint{
+			staticConf,
+			&gomavlib.EndpointTCPServer{
+				Address: "127.0.0.1:3346",
+			},
+		},
+		OutVersion:       gomavlib.V1,
+		OutSystemID:      22,
+		OutComponentID:   13,
+		Dialect:          ardupilotmega.Dialect,
+		HeartbeatDisable: true,
+	}
+	err := node.Initialize()
+	require.NoError(t, err)
+	defer node.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	w := &writer.Writer{Ctx: ctx, Wg: &wg, Node: node}
+	err = w.Initialize()
+	require.NoError(t, err)
+
+	m := &messageman.Manager{
+		Ctx:              ctx,
+		Wg:               &wg,
+		StreamReqDisable: true,
+		Node:             node,
+		Writer:           w,
+		StaticRoutes: []messageman.StaticRoute{{
+			SystemID: 99,
+			Endpoint: staticConf,
+		}},
+	}
+	err = m.Initialize()
+	require.NoError(t, err)
+
+	clients := make([]*gomavlib.Node, 2)
+
+	for i, port := range []string{"3345", "3346"} {
+		clients[i] = &gomavlib.Node{
+			Endpoints: []gomavlib.Endpoint{
+				&gomavlib.EndpointTCPClient{
+					Address: "127.0.0.1:" + port,
+				},
+			},
+			OutVersion:       gomavlib.V1,
+			OutSystemID:      99,
+			OutComponentID:   34,
+			HeartbeatDisable: true,
+		}
+		err = clients[i].Initialize()
+		require.NoError(t, err)
+		defer clients[i].Close()
+
+		evt := <-node.Events()
+		<-clients[i].Events()
+		w.ProcessChannelOpen(evt.(*gomavlib.EventChannelOpen))
+		m.ProcessChannelOpen(evt.(*gomavlib.EventChannelOpen))
+	}
+
+	fr := &frame.V2Frame{
+		SequenceNumber: 127,
+		SystemID:       30,
+		ComponentID:    17,
+		Message: &ardupilotmega.MessageOsdParamConfig{
+			TargetSystem:    99,
+			TargetComponent: 34,
+		},
+	}
+	err = node.FixFrame(fr)
+	require.NoError(t, err)
+
+	m.ProcessFrame(&gomavlib.EventFrame{
+		Frame: fr,
+	})
+
+	evt := <-clients[0].Events()
+	require.Equal(t, uint32(11033), evt.(*gomavlib.EventFrame).Frame.GetMessage().GetID())
+
+	select {
+	case evt = <-clients[1].Events():
+		t.Errorf("unexpected event: %#v", evt)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	cancel()
+	wg.Wait()
+}
+
+func TestNodeTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	rg := &registry.Registry{
+		Ctx:            ctx,
+		Wg:             &wg,
+		Timeout:        200 * time.Millisecond,
+		StickyVehicles: true,
+	}
+	err := rg.Initialize()
+	require.NoError(t, err)
+
+	m := &messageman.Manager{
+		Ctx:              ctx,
+		Wg:               &wg,
+		StreamReqDisable: true,
+		Registry:         rg,
+		SweepPeriod:      50 * time.Millisecond,
+	}
+	err = m.Initialize()
+	require.NoError(t, err)
+
+	ch := &gomavlib.Channel{}
+
+	// frames reach the registry before the manager, as in the router
+	process := func(evt *gomavlib.EventFrame) {
+		rg.ProcessFrame(evt)
+		m.ProcessFrame(evt)
+	}
+
+	// vehicle
+	process(&gomavlib.EventFrame{
+		Frame: &frame.V2Frame{
+			SystemID:    1,
+			ComponentID: 1,
+			Message: &common.MessageHeartbeat{
+				Autopilot: common.MAV_AUTOPILOT_ARDUPILOTMEGA,
+			},
+		},
+		Channel: ch,
+	})
+
+	// ground station
+	process(&gomavlib.EventFrame{
+		Frame: &frame.V2Frame{
+			SystemID:    255,
+			ComponentID: 190,
+			Message: &common.MessageHeartbeat{
+				Autopilot: common.MAV_AUTOPILOT_INVALID,
+			},
+		},
+		Channel: ch,
+	})
+
+	require.Len(t, m.Nodes(), 2)
+
+	time.Sleep(500 * time.Millisecond)
+
+	nodes := m.Nodes()
+	require.Len(t, nodes, 1)
+	require.Equal(t, byte(1), nodes[0].SystemID)
+
+	rg.ProcessChannelClose(&gomavlib.EventChannelClose{Channel: ch})
+	m.ProcessChannelClose(&gomavlib.EventChannelClose{Channel: ch})
+	require.Empty(t, m.Nodes())
+	require.Empty(t, rg.Components())
+
+	cancel()
+	wg.Wait()
+}
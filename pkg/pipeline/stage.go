@@ -0,0 +1,142 @@
+// Package pipeline contains the stages of the event pipeline.
+package pipeline
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gomavlib/v4"
+)
+
+const (
+	defaultQueueSize = 1024
+	reportPeriod     = 10 * time.Second
+)
+
+// Stats are the statistics of a stage.
+type Stats struct {
+	Name    string
+	Queued  int
+	Dropped uint64
+}
+
+// Stage processes events in a dedicated goroutine,
+// in order not to delay the stage that pushes them.
+type Stage struct {
+	Ctx  context.Context
+	Wg   *sync.WaitGroup
+	Name string
+
+	// maximum number of events in the queue.
+	// It defaults to 1024.
+	QueueSize int
+
+	// discard events when the queue is full, instead of waiting.
+	// Channel events are never discarded, since they change the state of the stage.
+	Lossy bool
+
+	// called for each event.
+	Process func(gomavlib.Event)
+
+	Log *slog.Logger
+
+	queue chan gomavlib.Event
+
+	mutex    sync.Mutex
+	dropped  uint64
+	reported uint64
+}
+
+// Initialize initializes a Stage.
+func (s *Stage) Initialize() error {
+	if s.Log == nil {
+		s.Log = slog.Default()
+	}
+
+	if s.QueueSize == 0 {
+		s.QueueSize = defaultQueueSize
+	}
+
+	s.queue = make(chan gomavlib.Event, s.QueueSize)
+
+	s.Wg.Add(1)
+	go s.run()
+
+	return nil
+}
+
+func (s *Stage) run() {
+	defer s.Wg.Done()
+
+	ticker := time.NewTicker(reportPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case evt := <-s.queue:
+			s.Process(evt)
+
+		case <-ticker.C:
+			s.report()
+
+		case <-s.Ctx.Done():
+			return
+		}
+	}
+}
+
+// report logs events that have been dropped since the last report.
+func (s *Stage) report() {
+	s.mutex.Lock()
+	n := s.dropped - s.reported
+	s.reported = s.dropped
+	s.mutex.Unlock()
+
+	if n != 0 {
+		s.Log.Warn("stage is too slow, events dropped", slog.String("stage", s.Name), slog.Uint64("count", n))
+	}
+}
+
+func isChannelEvent(evt gomavlib.Event) bool {
+	switch evt.(type) {
+	case *gomavlib.EventChannelOpen, *gomavlib.EventChannelClose:
+		return true
+	}
+	return false
+}
+
+// Push queues an event. It returns false if the event has been dropped.
+func (s *Stage) Push(evt gomavlib.Event) bool {
+	if s.Lossy && !isChannelEvent(evt) {
+		select {
+		case s.queue <- evt:
+			return true
+		default:
+			s.mutex.Lock()
+			s.dropped++
+			s.mutex.Unlock()
+			return false
+		}
+	}
+
+	select {
+	case s.queue <- evt:
+		return true
+	case <-s.Ctx.Done():
+		return false
+	}
+}
+
+// Stats returns the statistics of the stage.
+func (s *Stage) Stats() Stats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return Stats{
+		Name:    s.Name,
+		Queued:  len(s.queue),
+		Dropped: s.dropped,
+	}
+}
@@ -0,0 +1,76 @@
+package pipeline_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/bluenviron/gomavlib/v4"
+	"github.com/bluenviron/gomavlib/v4/pkg/dialects/common"
+	"github.com/bluenviron/gomavlib/v4/pkg/frame"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bluenviron/mavp2p/pkg/pipeline"
+)
+
+func TestStage(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	processed := make(chan gomavlib.Event, 10)
+
+	s := &pipeline.Stage{
+		Ctx:       ctx,
+		Wg:        &wg,
+		Name:      "test",
+		QueueSize: 2,
+		Lossy:     true,
+		Process: func(evt gomavlib.Event) {
+			if len(processed) == 0 {
+				select {
+				case <-started:
+				default:
+					close(started)
+					<-release
+				}
+			}
+			processed <- evt
+		},
+	}
+	err := s.Initialize()
+	require.NoError(t, err)
+
+	frameEvent := func(seq byte) *gomavlib.EventFrame {
+		return &gomavlib.EventFrame{Frame: &frame.V2Frame{SequenceNumber: seq, Message: &common.MessageHeartbeat{}}}
+	}
+
+	require.True(t, s.Push(frameEvent(0)))
+	<-started
+
+	require.True(t, s.Push(frameEvent(1)))
+	require.True(t, s.Push(frameEvent(2)))
+	require.False(t, s.Push(frameEvent(3)))
+	require.Equal(t, pipeline.Stats{Name: "test", Queued: 2, Dropped: 1}, s.Stats())
+
+	// other events wait for the queue to be available
+	closeEvent := &gomavlib.EventChannelClose{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Push(closeEvent)
+	}()
+
+	close(release)
+	<-done
+
+	for _, seq := range []byte{0, 1, 2} {
+		evt := <-processed
+		require.Equal(t, seq, evt.(*gomavlib.EventFrame).Frame.GetSequenceNumber())
+	}
+	require.Equal(t, closeEvent, <-processed)
+
+	cancel()
+	wg.Wait()
+}
@@ -0,0 +1,240 @@
+// Package printer contains the frame printer.
+package printer
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/bluenviron/gomavlib/v4"
+	"github.com/bluenviron/gomavlib/v4/pkg/dialect"
+	"github.com/bluenviron/gomavlib/v4/pkg/frame"
+	"github.com/bluenviron/gomavlib/v4/pkg/message"
+)
+
+const timeFormat = "15:04:05.000"
+
+var (
+	timeNow         = time.Now
+	channelEndpoint = func(ch *gomavlib.Channel) gomavlib.Endpoint { return ch.Endpoint() }
+)
+
+// snakeCase converts a Go identifier into its MAVLink form,
+// i.e. GpsRawInt into GPS_RAW_INT.
+func snakeCase(name string) string {
+	var b strings.Builder
+	runes := []rune(name)
+
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) &&
+			(unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1])) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+
+	return b.String()
+}
+
+// MessageName returns the MAVLink name of a message, i.e. HEARTBEAT.
+func MessageName(msg message.Message) string {
+	if raw, ok := msg.(*message.MessageRaw); ok {
+		return "MSG_ID_" + strconv.FormatUint(uint64(raw.ID), 10)
+	}
+
+	return snakeCase(strings.TrimPrefix(reflect.TypeOf(msg).Elem().Name(), "Message"))
+}
+
+func fieldName(f reflect.StructField) string {
+	if name := f.Tag.Get("mavname"); name != "" {
+		return name
+	}
+	return strings.ToLower(snakeCase(f.Name))
+}
+
+func formatValue(v reflect.Value) string {
+	if s, ok := v.Interface().(fmt.Stringer); ok {
+		return s.String()
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return strconv.Quote(v.String())
+
+	case reflect.Float32:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 32)
+
+	case reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64)
+
+	case reflect.Array, reflect.Slice:
+		parts := make([]string, v.Len())
+		for i := range parts {
+			parts[i] = formatValue(v.Index(i))
+		}
+		return "[" + strings.Join(parts, ",") + "]"
+	}
+
+	return fmt.Sprint(v.Interface())
+}
+
+// Printer prints frames in a readable format,
+// one line per frame, with the message fields in the key=value format.
+type Printer struct {
+	Writer io.Writer
+
+	// dialect used to decode messages that have not been decoded yet.
+	// It can be nil.
+	Dialect *dialect.Dialect
+
+	// if not empty, print only these messages, i.e. HEARTBEAT.
+	Messages []string
+
+	// if not empty, print only messages sent by these systems.
+	SystemIDs []int
+
+	// if not empty, print only messages received from these endpoints.
+	Channels []gomavlib.Endpoint
+
+	dialectRW *dialect.ReadWriter
+	messages  map[string]struct{}
+	systemIDs map[byte]struct{}
+	channels  map[gomavlib.Endpoint]struct{}
+}
+
+// Initialize initializes a Printer.
+func (p *Printer) Initialize() error {
+	if p.Dialect != nil {
+		p.dialectRW = &dialect.ReadWriter{Dialect: p.Dialect}
+		err := p.dialectRW.Initialize()
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(p.Messages) != 0 {
+		known := make(map[string]struct{})
+		if p.Dialect != nil {
+			for _, msg := range p.Dialect.Messages {
+				known[MessageName(msg)] = struct{}{}
+			}
+		}
+
+		p.messages = make(map[string]struct{})
+		for _, name := range p.Messages {
+			name = strings.ToUpper(name)
+
+			_, ok := known[name]
+			if !ok && !strings.HasPrefix(name, "MSG_ID_") {
+				return fmt.Errorf("message not found in dialect: %s", name)
+			}
+
+			p.messages[name] = struct{}{}
+		}
+	}
+
+	if len(p.SystemIDs) != 0 {
+		p.systemIDs = make(map[byte]struct{})
+		for _, id := range p.SystemIDs {
+			if id < 0 || id > 255 {
+				return fmt.Errorf("invalid system ID: %d", id)
+			}
+			p.systemIDs[byte(id)] = struct{}{}
+		}
+	}
+
+	if len(p.Channels) != 0 {
+		p.channels = make(map[gomavlib.Endpoint]struct{})
+		for _, e := range p.Channels {
+			p.channels[e] = struct{}{}
+		}
+	}
+
+	return nil
+}
+
+func (p *Printer) decode(fr frame.Frame) message.Message {
+	msg := fr.GetMessage()
+
+	raw, ok := msg.(*message.MessageRaw)
+	if !ok || p.dialectRW == nil {
+		return msg
+	}
+
+	mrw := p.dialectRW.GetMessage(raw.ID)
+	if mrw == nil {
+		return msg
+	}
+
+	_, isV2 := fr.(*frame.V2Frame)
+	decoded, err := mrw.Read(raw, isV2)
+	if err != nil {
+		return msg
+	}
+
+	return decoded
+}
+
+// ProcessFrame processes a EventFrame.
+func (p *Printer) ProcessFrame(evt *gomavlib.EventFrame) {
+	if p.systemIDs != nil {
+		if _, ok := p.systemIDs[evt.SystemID()]; !ok {
+			return
+		}
+	}
+
+	if p.channels != nil {
+		if _, ok := p.channels[channelEndpoint(evt.Channel)]; !ok {
+			return
+		}
+	}
+
+	msg := p.decode(evt.Frame)
+	name := MessageName(msg)
+
+	if p.messages != nil {
+		if _, ok := p.messages[name]; !ok {
+			return
+		}
+	}
+
+	var b strings.Builder
+
+	b.WriteString(timeNow().Format(timeFormat))
+	b.WriteByte(' ')
+	b.WriteString(fmt.Sprint(evt.Channel))
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatUint(uint64(evt.SystemID()), 10))
+	b.WriteByte('/')
+	b.WriteString(strconv.FormatUint(uint64(evt.ComponentID()), 10))
+	b.WriteByte(' ')
+	b.WriteString(name)
+
+	if raw, ok := msg.(*message.MessageRaw); ok {
+		b.WriteString(" len=")
+		b.WriteString(strconv.Itoa(len(raw.Payload)))
+	} else {
+		rv := reflect.ValueOf(msg).Elem()
+		rt := rv.Type()
+
+		for i := 0; i < rt.NumField(); i++ {
+			f := rt.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+
+			b.WriteByte(' ')
+			b.WriteString(fieldName(f))
+			b.WriteByte('=')
+			b.WriteString(formatValue(rv.Field(i)))
+		}
+	}
+
+	b.WriteByte('\n')
+
+	io.WriteString(p.Writer, b.String()) //nolint:errcheck
+}
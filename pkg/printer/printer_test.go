@@ -0,0 +1,109 @@
+package printer
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gomavlib/v4"
+	"github.com/bluenviron/gomavlib/v4/pkg/dialect"
+	"github.com/bluenviron/gomavlib/v4/pkg/frame"
+	"github.com/bluenviron/gomavlib/v4/pkg/message"
+	"github.com/stretchr/testify/require"
+)
+
+type MessageGps2Raw struct {
+	TimeUsec  uint64
+	Lat       int32
+	Alt       float32
+	Name      string
+	Satellite [3]uint8
+	Other     uint8 `mavname:"other_name"`
+}
+
+func (*MessageGps2Raw) GetID() uint32 {
+	return 124
+}
+
+func TestSnakeCase(t *testing.T) {
+	for _, ca := range [][2]string{
+		{"Heartbeat", "HEARTBEAT"},
+		{"GpsRawInt", "GPS_RAW_INT"},
+		{"Gps2Raw", "GPS2_RAW"},
+		{"ScaledImu2", "SCALED_IMU2"},
+		{"V2Extension", "V2_EXTENSION"},
+	} {
+		require.Equal(t, ca[1], snakeCase(ca[0]))
+	}
+}
+
+func TestPrinter(t *testing.T) {
+	timeNow = func() time.Time {
+		return time.Date(2020, 1, 1, 12, 30, 15, 250000000, time.UTC)
+	}
+	defer func() { timeNow = time.Now }()
+
+	e1 := &gomavlib.EndpointUDPServer{Address: "1"}
+	e2 := &gomavlib.EndpointUDPServer{Address: "2"}
+	ch1 := &gomavlib.Channel{}
+	ch2 := &gomavlib.Channel{}
+
+	channelEndpoints := map[*gomavlib.Channel]gomavlib.Endpoint{
+		ch1: e1,
+		ch2: e2,
+	}
+	channelEndpoint = func(ch *gomavlib.Channel) gomavlib.Endpoint {
+		return channelEndpoints[ch]
+	}
+	defer func() { channelEndpoint = func(ch *gomavlib.Channel) gomavlib.Endpoint { return ch.Endpoint() } }()
+
+	var buf bytes.Buffer
+
+	p := &Printer{
+		Writer:    &buf,
+		Dialect:   &dialect.Dialect{Version: 3, Messages: []message.Message{&MessageGps2Raw{}}},
+		Messages:  []string{"gps2_raw", "MSG_ID_77"},
+		SystemIDs: []int{1},
+		Channels:  []gomavlib.Endpoint{e1},
+	}
+	err := p.Initialize()
+	require.NoError(t, err)
+
+	msg := &MessageGps2Raw{
+		TimeUsec:  123,
+		Lat:       -5,
+		Alt:       1.5,
+		Name:      "test",
+		Satellite: [3]uint8{1, 2, 3},
+		Other:     4,
+	}
+
+	for _, fr := range []frame.Frame{
+		&frame.V2Frame{SystemID: 1, ComponentID: 2, Message: msg},
+		&frame.V2Frame{SystemID: 2, ComponentID: 2, Message: msg},
+		&frame.V1Frame{SystemID: 1, ComponentID: 3, Message: &message.MessageRaw{ID: 77, Payload: []byte{1, 2}}},
+		&frame.V1Frame{SystemID: 1, ComponentID: 3, Message: &message.MessageRaw{ID: 78, Payload: []byte{1, 2}}},
+	} {
+		p.ProcessFrame(&gomavlib.EventFrame{
+			Frame:   fr,
+			Channel: ch1,
+		})
+	}
+
+	p.ProcessFrame(&gomavlib.EventFrame{
+		Frame:   &frame.V2Frame{SystemID: 1, ComponentID: 2, Message: msg},
+		Channel: ch2,
+	})
+
+	require.Equal(t, "12:30:15.250  1/2 GPS2_RAW time_usec=123 lat=-5 alt=1.5 name=\"test\" satellite=[1,2,3] other_name=4\n"+
+		"12:30:15.250  1/3 MSG_ID_77 len=2\n", buf.String())
+}
+
+func TestPrinterUnknownMessage(t *testing.T) {
+	p := &Printer{
+		Dialect:  &dialect.Dialect{Version: 3, Messages: []message.Message{&MessageGps2Raw{}}},
+		Messages: []string{"ATTITUDE"},
+	}
+	err := p.Initialize()
+	require.EqualError(t, err, "message not found in dialect: ATTITUDE")
+}
@@ -0,0 +1,435 @@
+// Package registry contains the component registry.
+package registry
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gomavlib/v4"
+	"github.com/bluenviron/gomavlib/v4/pkg/dialects/common"
+	"github.com/bluenviron/gomavlib/v4/pkg/frame"
+
+	"github.com/bluenviron/mavp2p/pkg/logger"
+)
+
+const (
+	sweepPeriod    = 1 * time.Second
+	defaultTimeout = 30 * time.Second
+)
+
+var (
+	timeNow         = time.Now
+	channelEndpoint = func(ch *gomavlib.Channel) gomavlib.Endpoint { return ch.Endpoint() }
+)
+
+// EventType is the type of an Event.
+type EventType int
+
+// event types.
+const (
+	EventAppeared EventType = iota
+	EventDisappeared
+	EventArmed
+	EventDisarmed
+	EventModeChanged
+	EventStatusChanged
+	EventVersionReceived
+)
+
+var eventTypeLabels = map[EventType]string{
+	EventAppeared:        "appeared",
+	EventDisappeared:     "disappeared",
+	EventArmed:           "armed",
+	EventDisarmed:        "disarmed",
+	EventModeChanged:     "mode_changed",
+	EventStatusChanged:   "status_changed",
+	EventVersionReceived: "version_received",
+}
+
+// String implements fmt.Stringer.
+func (t EventType) String() string {
+	return eventTypeLabels[t]
+}
+
+// Version is the content of AUTOPILOT_VERSION.
+type Version struct {
+	Capabilities        common.MAV_PROTOCOL_CAPABILITY
+	FlightSwVersion     uint32
+	MiddlewareSwVersion uint32
+	OsSwVersion         uint32
+	BoardVersion        uint32
+	VendorID            uint16
+	ProductID           uint16
+	UID                 uint64
+}
+
+// Component is a remote component, described by its HEARTBEAT
+// and by its AUTOPILOT_VERSION, when available.
+type Component struct {
+	SystemID       byte
+	ComponentID    byte
+	Channel        string
+	Type           common.MAV_TYPE
+	Autopilot      common.MAV_AUTOPILOT
+	BaseMode       common.MAV_MODE_FLAG
+	CustomMode     uint32
+	SystemStatus   common.MAV_STATE
+	Armed          bool
+	MavlinkVersion int
+	Version        *Version
+	FirstSeen      time.Time
+	LastHeartbeat  time.Time
+
+	channel *gomavlib.Channel
+}
+
+// IsVehicle returns whether the component is an autopilot.
+// Ground stations and peripherals are excluded even when they advertise
+// a generic autopilot, as some ground stations do.
+func (c Component) IsVehicle() bool {
+	if c.Autopilot == common.MAV_AUTOPILOT_INVALID {
+		return false
+	}
+
+	switch c.Type {
+	case common.MAV_TYPE_GCS,
+		common.MAV_TYPE_ONBOARD_CONTROLLER,
+		common.MAV_TYPE_GIMBAL,
+		common.MAV_TYPE_ADSB,
+		common.MAV_TYPE_CAMERA,
+		common.MAV_TYPE_ANTENNA_TRACKER:
+		return false
+	}
+
+	return true
+}
+
+// Event is emitted when the state of a component changes.
+type Event struct {
+	Type      EventType
+	Component Component
+}
+
+type componentKey struct {
+	systemID    byte
+	componentID byte
+}
+
+// Registry is a registry of remote components, built from HEARTBEAT and AUTOPILOT_VERSION.
+// It decides when remote nodes are inactive.
+type Registry struct {
+	Ctx context.Context
+	Wg  *sync.WaitGroup
+
+	// components that do not send heartbeats within this timeout are removed.
+	// It defaults to 30 seconds.
+	Timeout time.Duration
+
+	// overrides Timeout for components reachable through specific endpoints.
+	EndpointTimeouts map[gomavlib.Endpoint]time.Duration
+
+	// keep vehicles, i.e. components that sent a HEARTBEAT with a valid autopilot,
+	// even after a period of inactivity. They are removed when their channel is closed.
+	StickyVehicles bool
+
+	// called when the state of a component changes. It can be nil.
+	OnEvent func(Event)
+
+	Log *slog.Logger
+
+	mutex      sync.Mutex
+	components map[componentKey]*Component
+}
+
+// Initialize initializes a Registry.
+func (r *Registry) Initialize() error {
+	if r.Log == nil {
+		r.Log = slog.Default()
+	}
+
+	if r.Timeout == 0 {
+		r.Timeout = defaultTimeout
+	}
+
+	r.components = make(map[componentKey]*Component)
+
+	r.Wg.Add(1)
+	go r.run()
+
+	return nil
+}
+
+func (r *Registry) run() {
+	defer r.Wg.Done()
+
+	ticker := time.NewTicker(sweepPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.sweep()
+
+		case <-r.Ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *Registry) sweep() {
+	now := timeNow()
+	var events []Event
+
+	func() {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+
+		for key, c := range r.components {
+			if r.expired(c.channel, c, c.LastHeartbeat, now) {
+				delete(r.components, key)
+				events = append(events, Event{Type: EventDisappeared, Component: *c})
+			}
+		}
+	}()
+
+	r.emit(events)
+}
+
+func (r *Registry) timeout(ch *gomavlib.Channel) time.Duration {
+	if len(r.EndpointTimeouts) != 0 {
+		if timeout, ok := r.EndpointTimeouts[channelEndpoint(ch)]; ok {
+			return timeout
+		}
+	}
+	return r.Timeout
+}
+
+func (r *Registry) expired(ch *gomavlib.Channel, c *Component, lastSeen time.Time, now time.Time) bool {
+	if r.StickyVehicles && c != nil && c.IsVehicle() {
+		return false
+	}
+	return now.Sub(lastSeen) >= r.timeout(ch)
+}
+
+// Expired returns whether a node that was last seen through a channel at the given time is inactive.
+func (r *Registry) Expired(ch *gomavlib.Channel, systemID byte, componentID byte, lastSeen time.Time) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.expired(ch, r.components[componentKey{systemID, componentID}], lastSeen, timeNow())
+}
+
+func (r *Registry) emit(events []Event) {
+	for _, evt := range events {
+		c := evt.Component
+		attrs := []any{
+			logger.SystemID(c.SystemID),
+			logger.ComponentID(c.ComponentID),
+		}
+
+		switch evt.Type {
+		case EventAppeared:
+			attrs = append(attrs,
+				slog.String("type", c.Type.String()),
+				slog.String("autopilot", c.Autopilot.String()),
+				slog.Int("mavlink_version", c.MavlinkVersion))
+
+		case EventModeChanged:
+			attrs = append(attrs,
+				slog.Uint64("base_mode", uint64(c.BaseMode)),
+				slog.Uint64("custom_mode", uint64(c.CustomMode)))
+
+		case EventStatusChanged:
+			attrs = append(attrs, slog.String("status", c.SystemStatus.String()))
+
+		case EventVersionReceived:
+			attrs = append(attrs, slog.Uint64("flight_sw_version", uint64(c.Version.FlightSwVersion)))
+		}
+
+		r.Log.Info("component "+evt.Type.String(), attrs...)
+
+		if r.OnEvent != nil {
+			r.OnEvent(evt)
+		}
+	}
+}
+
+// ProcessFrame processes a EventFrame.
+func (r *Registry) ProcessFrame(evt *gomavlib.EventFrame) {
+	switch msg := evt.Message().(type) {
+	case *common.MessageHeartbeat:
+		r.processHeartbeat(evt, msg)
+
+	case *common.MessageAutopilotVersion:
+		r.processAutopilotVersion(evt, msg)
+	}
+}
+
+func (r *Registry) processHeartbeat(evt *gomavlib.EventFrame, msg *common.MessageHeartbeat) {
+	key := componentKey{evt.SystemID(), evt.ComponentID()}
+	now := timeNow()
+	var events []Event
+
+	mavlinkVersion := 1
+	if _, ok := evt.Frame.(*frame.V2Frame); ok {
+		mavlinkVersion = 2
+	}
+
+	armed := (msg.BaseMode & common.MAV_MODE_FLAG_SAFETY_ARMED) != 0
+
+	func() {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+
+		c, ok := r.components[key]
+		if !ok {
+			c = &Component{
+				SystemID:       key.systemID,
+				ComponentID:    key.componentID,
+				Channel:        fmt.Sprint(evt.Channel),
+				Type:           msg.Type,
+				Autopilot:      msg.Autopilot,
+				BaseMode:       msg.BaseMode,
+				CustomMode:     msg.CustomMode,
+				SystemStatus:   msg.SystemStatus,
+				Armed:          armed,
+				MavlinkVersion: mavlinkVersion,
+				FirstSeen:      now,
+				LastHeartbeat:  now,
+				channel:        evt.Channel,
+			}
+			r.components[key] = c
+			events = append(events, Event{Type: EventAppeared, Component: *c})
+
+			if armed {
+				events = append(events, Event{Type: EventArmed, Component: *c})
+			}
+			return
+		}
+
+		armChanged := armed != c.Armed
+		modeChanged := msg.BaseMode&^common.MAV_MODE_FLAG_SAFETY_ARMED != c.BaseMode&^common.MAV_MODE_FLAG_SAFETY_ARMED ||
+			msg.CustomMode != c.CustomMode
+		statusChanged := msg.SystemStatus != c.SystemStatus
+
+		c.Channel = fmt.Sprint(evt.Channel)
+		c.channel = evt.Channel
+		c.Type = msg.Type
+		c.Autopilot = msg.Autopilot
+		c.BaseMode = msg.BaseMode
+		c.CustomMode = msg.CustomMode
+		c.SystemStatus = msg.SystemStatus
+		c.Armed = armed
+		c.MavlinkVersion = mavlinkVersion
+		c.LastHeartbeat = now
+
+		if armChanged {
+			if armed {
+				events = append(events, Event{Type: EventArmed, Component: *c})
+			} else {
+				events = append(events, Event{Type: EventDisarmed, Component: *c})
+			}
+		}
+
+		if modeChanged {
+			events = append(events, Event{Type: EventModeChanged, Component: *c})
+		}
+
+		if statusChanged {
+			events = append(events, Event{Type: EventStatusChanged, Component: *c})
+		}
+	}()
+
+	r.emit(events)
+}
+
+func (r *Registry) processAutopilotVersion(evt *gomavlib.EventFrame, msg *common.MessageAutopilotVersion) {
+	key := componentKey{evt.SystemID(), evt.ComponentID()}
+	var events []Event
+
+	func() {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+
+		// AUTOPILOT_VERSION is taken into account only after a HEARTBEAT
+		c, ok := r.components[key]
+		if !ok {
+			return
+		}
+
+		v := &Version{
+			Capabilities:        msg.Capabilities,
+			FlightSwVersion:     msg.FlightSwVersion,
+			MiddlewareSwVersion: msg.MiddlewareSwVersion,
+			OsSwVersion:         msg.OsSwVersion,
+			BoardVersion:        msg.BoardVersion,
+			VendorID:            msg.VendorId,
+			ProductID:           msg.ProductId,
+			UID:                 msg.Uid,
+		}
+
+		if c.Version != nil && *c.Version == *v {
+			return
+		}
+
+		c.Version = v
+		events = append(events, Event{Type: EventVersionReceived, Component: *c})
+	}()
+
+	r.emit(events)
+}
+
+// ProcessChannelClose processes a EventChannelClose.
+func (r *Registry) ProcessChannelClose(evt *gomavlib.EventChannelClose) {
+	var events []Event
+
+	func() {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+
+		for key, c := range r.components {
+			if c.channel == evt.Channel {
+				delete(r.components, key)
+				events = append(events, Event{Type: EventDisappeared, Component: *c})
+			}
+		}
+	}()
+
+	r.emit(events)
+}
+
+// Component returns a component.
+func (r *Registry) Component(systemID byte, componentID byte) (Component, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	c, ok := r.components[componentKey{systemID, componentID}]
+	if !ok {
+		return Component{}, false
+	}
+	return *c, true
+}
+
+// Components returns all components.
+func (r *Registry) Components() []Component {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	ret := make([]Component, 0, len(r.components))
+	for _, c := range r.components {
+		ret = append(ret, *c)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].SystemID != ret[j].SystemID {
+			return ret[i].SystemID < ret[j].SystemID
+		}
+		return ret[i].ComponentID < ret[j].ComponentID
+	})
+
+	return ret
+}
@@ -0,0 +1,170 @@
+package registry
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gomavlib/v4"
+	"github.com/bluenviron/gomavlib/v4/pkg/dialects/common"
+	"github.com/bluenviron/gomavlib/v4/pkg/frame"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	var events []EventType
+
+	r := &Registry{
+		Ctx:     ctx,
+		Wg:      &wg,
+		Timeout: 5 * time.Second,
+		OnEvent: func(evt Event) {
+			require.Equal(t, byte(1), evt.Component.SystemID)
+			events = append(events, evt.Type)
+		},
+	}
+	err := r.Initialize()
+	require.NoError(t, err)
+
+	ch := &gomavlib.Channel{}
+
+	send := func(fr frame.Frame) {
+		r.ProcessFrame(&gomavlib.EventFrame{Frame: fr, Channel: ch})
+	}
+
+	heartbeat := func(baseMode common.MAV_MODE_FLAG, customMode uint32, status common.MAV_STATE) {
+		send(&frame.V2Frame{
+			SystemID:    1,
+			ComponentID: 1,
+			Message: &common.MessageHeartbeat{
+				Type:         common.MAV_TYPE_QUADROTOR,
+				Autopilot:    common.MAV_AUTOPILOT_PX4,
+				BaseMode:     baseMode,
+				CustomMode:   customMode,
+				SystemStatus: status,
+			},
+		})
+	}
+
+	// version before heartbeat is ignored
+	send(&frame.V2Frame{SystemID: 1, ComponentID: 1, Message: &common.MessageAutopilotVersion{FlightSwVersion: 7}})
+
+	heartbeat(common.MAV_MODE_FLAG_CUSTOM_MODE_ENABLED, 3, common.MAV_STATE_STANDBY)
+	heartbeat(common.MAV_MODE_FLAG_CUSTOM_MODE_ENABLED, 3, common.MAV_STATE_STANDBY)
+	heartbeat(common.MAV_MODE_FLAG_CUSTOM_MODE_ENABLED|common.MAV_MODE_FLAG_SAFETY_ARMED, 3, common.MAV_STATE_ACTIVE)
+	heartbeat(common.MAV_MODE_FLAG_CUSTOM_MODE_ENABLED|common.MAV_MODE_FLAG_SAFETY_ARMED, 4, common.MAV_STATE_ACTIVE)
+	send(&frame.V2Frame{SystemID: 1, ComponentID: 1, Message: &common.MessageAutopilotVersion{FlightSwVersion: 7}})
+	send(&frame.V2Frame{SystemID: 1, ComponentID: 1, Message: &common.MessageAutopilotVersion{FlightSwVersion: 7}})
+	heartbeat(common.MAV_MODE_FLAG_CUSTOM_MODE_ENABLED, 4, common.MAV_STATE_ACTIVE)
+
+	require.Equal(t, []EventType{
+		EventAppeared,
+		EventArmed,
+		EventStatusChanged,
+		EventModeChanged,
+		EventVersionReceived,
+		EventDisarmed,
+	}, events)
+
+	c, ok := r.Component(1, 1)
+	require.True(t, ok)
+	require.Equal(t, common.MAV_TYPE_QUADROTOR, c.Type)
+	require.Equal(t, uint32(4), c.CustomMode)
+	require.False(t, c.Armed)
+	require.Equal(t, 2, c.MavlinkVersion)
+	require.Equal(t, uint32(7), c.Version.FlightSwVersion)
+	require.True(t, c.IsVehicle())
+
+	events = nil
+	now = now.Add(5 * time.Second)
+	r.sweep()
+	require.Equal(t, []EventType{EventDisappeared}, events)
+	require.Empty(t, r.Components())
+
+	cancel()
+	wg.Wait()
+}
+
+func TestRegistryExpiry(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	slowEndpoint := &gomavlib.EndpointSerial{}
+	slowCh := &gomavlib.Channel{}
+	ch := &gomavlib.Channel{}
+
+	channelEndpoint = func(c *gomavlib.Channel) gomavlib.Endpoint {
+		if c == slowCh {
+			return slowEndpoint
+		}
+		return &gomavlib.EndpointUDPServer{}
+	}
+	defer func() {
+		channelEndpoint = func(ch *gomavlib.Channel) gomavlib.Endpoint { return ch.Endpoint() }
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	r := &Registry{
+		Ctx:              ctx,
+		Wg:               &wg,
+		Timeout:          5 * time.Second,
+		EndpointTimeouts: map[gomavlib.Endpoint]time.Duration{slowEndpoint: 20 * time.Second},
+		StickyVehicles:   true,
+	}
+	err := r.Initialize()
+	require.NoError(t, err)
+
+	heartbeat := func(c *gomavlib.Channel, systemID byte, typ common.MAV_TYPE, autopilot common.MAV_AUTOPILOT) {
+		r.ProcessFrame(&gomavlib.EventFrame{
+			Frame: &frame.V2Frame{
+				SystemID:    systemID,
+				ComponentID: 1,
+				Message:     &common.MessageHeartbeat{Type: typ, Autopilot: autopilot},
+			},
+			Channel: c,
+		})
+	}
+
+	heartbeat(ch, 1, common.MAV_TYPE_GENERIC, common.MAV_AUTOPILOT_ARDUPILOTMEGA)
+	heartbeat(ch, 255, common.MAV_TYPE_GCS, common.MAV_AUTOPILOT_INVALID)
+	heartbeat(slowCh, 254, common.MAV_TYPE_GCS, common.MAV_AUTOPILOT_INVALID)
+
+	// ground stations that advertise a generic autopilot are not vehicles
+	heartbeat(ch, 253, common.MAV_TYPE_GCS, common.MAV_AUTOPILOT_GENERIC)
+	c, ok := r.Component(253, 1)
+	require.True(t, ok)
+	require.False(t, c.IsVehicle())
+
+	// nodes that did not send a heartbeat follow the same rules
+	require.False(t, r.Expired(slowCh, 10, 1, now.Add(-10*time.Second)))
+	require.True(t, r.Expired(ch, 10, 1, now.Add(-10*time.Second)))
+	require.False(t, r.Expired(ch, 1, 1, now.Add(-time.Hour)))
+
+	now = now.Add(10 * time.Second)
+	r.sweep()
+
+	components := r.Components()
+	require.Len(t, components, 2)
+	require.Equal(t, byte(1), components[0].SystemID)
+	require.Equal(t, byte(254), components[1].SystemID)
+
+	// sticky vehicles are removed when their channel is closed
+	r.ProcessChannelClose(&gomavlib.EventChannelClose{Channel: ch})
+	components = r.Components()
+	require.Len(t, components, 1)
+	require.Equal(t, byte(254), components[0].SystemID)
+
+	cancel()
+	wg.Wait()
+}
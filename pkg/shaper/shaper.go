@@ -0,0 +1,552 @@
+// Package shaper contains the bandwidth shaper.
+package shaper
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gomavlib/v4"
+	"github.com/bluenviron/gomavlib/v4/pkg/dialects/common"
+	"github.com/bluenviron/gomavlib/v4/pkg/frame"
+
+	"github.com/bluenviron/mavp2p/pkg/logger"
+	"github.com/bluenviron/mavp2p/pkg/traffic"
+	"github.com/bluenviron/mavp2p/pkg/writer"
+)
+
+const (
+	defaultQueueSize = 64
+	reportPeriod     = 10 * time.Second
+
+	// the bucket holds at most the budget of this period,
+	// in order to limit bursts after idle periods.
+	burstPeriod = 100 * time.Millisecond
+
+	// maximum size of a frame, that is the minimum size of the bucket.
+	maxFrameSize = 280
+
+	// flow control parameters. When the free space of the radio buffer (txbuf)
+	// is below slowTxBuf, the delay between low priority frames is increased;
+	// when it is above fastTxBuf, the delay is decreased;
+	// when it is below pauseTxBuf, low priority frames are held back.
+	slowTxBuf          = 50
+	fastTxBuf          = 90
+	pauseTxBuf         = 20
+	delayStep          = 20 * time.Millisecond
+	maxDelay           = 1 * time.Second
+	radioStatusTimeout = 5 * time.Second
+)
+
+var (
+	timeNow         = time.Now
+	channelEndpoint = func(ch *gomavlib.Channel) gomavlib.Endpoint { return ch.Endpoint() }
+	writeFrame      = func(w *writer.Writer, ch *gomavlib.Channel, fr frame.Frame) {
+		w.Write(ch, fr)
+	}
+)
+
+// Class is a priority class.
+type Class int
+
+// classes, from the highest priority to the lowest.
+const (
+	// commands, acknowledgements, heartbeats and manual control.
+	ClassControl Class = iota
+
+	// parameters and missions.
+	ClassParameters
+
+	// telemetry, that is every other message.
+	ClassTelemetry
+
+	// file and log transfers.
+	ClassBulk
+
+	classCount
+)
+
+var classLabels = map[Class]string{
+	ClassControl:    "control",
+	ClassParameters: "parameters",
+	ClassTelemetry:  "telemetry",
+	ClassBulk:       "bulk",
+}
+
+// String implements fmt.Stringer.
+func (c Class) String() string {
+	return classLabels[c]
+}
+
+var messageClasses = map[uint32]Class{
+	0:  ClassControl, // HEARTBEAT
+	11: ClassControl, // SET_MODE
+	69: ClassControl, // MANUAL_CONTROL
+	70: ClassControl, // RC_CHANNELS_OVERRIDE
+	75: ClassControl, // COMMAND_INT
+	76: ClassControl, // COMMAND_LONG
+	77: ClassControl, // COMMAND_ACK
+	80: ClassControl, // COMMAND_CANCEL
+	84: ClassControl, // SET_POSITION_TARGET_LOCAL_NED
+	86: ClassControl, // SET_POSITION_TARGET_GLOBAL_INT
+
+	20:  ClassParameters, // PARAM_REQUEST_READ
+	21:  ClassParameters, // PARAM_REQUEST_LIST
+	22:  ClassParameters, // PARAM_VALUE
+	23:  ClassParameters, // PARAM_SET
+	39:  ClassParameters, // MISSION_ITEM
+	40:  ClassParameters, // MISSION_REQUEST
+	41:  ClassParameters, // MISSION_SET_CURRENT
+	43:  ClassParameters, // MISSION_REQUEST_LIST
+	44:  ClassParameters, // MISSION_COUNT
+	45:  ClassParameters, // MISSION_CLEAR_ALL
+	47:  ClassParameters, // MISSION_ACK
+	51:  ClassParameters, // MISSION_REQUEST_INT
+	73:  ClassParameters, // MISSION_ITEM_INT
+	320: ClassParameters, // PARAM_EXT_REQUEST_READ
+	321: ClassParameters, // PARAM_EXT_REQUEST_LIST
+	322: ClassParameters, // PARAM_EXT_VALUE
+	323: ClassParameters, // PARAM_EXT_SET
+	324: ClassParameters, // PARAM_EXT_ACK
+
+	110: ClassBulk, // FILE_TRANSFER_PROTOCOL
+	117: ClassBulk, // LOG_REQUEST_LIST
+	118: ClassBulk, // LOG_ENTRY
+	119: ClassBulk, // LOG_REQUEST_DATA
+	120: ClassBulk, // LOG_DATA
+	121: ClassBulk, // LOG_ERASE
+	122: ClassBulk, // LOG_REQUEST_END
+	130: ClassBulk, // DATA_TRANSMISSION_HANDSHAKE
+	131: ClassBulk, // ENCAPSULATED_DATA
+}
+
+// MessageClass returns the priority class of a message.
+func MessageClass(id uint32) Class {
+	if c, ok := messageClasses[id]; ok {
+		return c
+	}
+	return ClassTelemetry
+}
+
+// Budget is the bandwidth budget of an endpoint.
+type Budget struct {
+	Endpoint gomavlib.Endpoint
+
+	// bytes per second.
+	Rate int
+}
+
+// queue is a set of FIFO queues, one for each priority class.
+type queue struct {
+	size    int
+	frames  [classCount][]frame.Frame
+	dropped [classCount]uint64
+}
+
+// push adds a frame to the queue of its class.
+// When the queue is full, telemetry discards its oldest frame
+// while other classes discard the new one, since they are not superseded by newer frames.
+func (q *queue) push(fr frame.Frame) {
+	c := MessageClass(fr.GetMessage().GetID())
+
+	if len(q.frames[c]) >= q.size {
+		q.dropped[c]++
+
+		if c != ClassTelemetry {
+			return
+		}
+
+		q.frames[c][0] = nil
+		q.frames[c] = q.frames[c][1:]
+	}
+
+	q.frames[c] = append(q.frames[c], fr)
+}
+
+// pop removes the first frame of the highest priority class,
+// among classes with priority equal or higher than maxClass.
+func (q *queue) pop(maxClass Class) (frame.Frame, Class) {
+	for c := Class(0); c <= maxClass; c++ {
+		if len(q.frames[c]) != 0 {
+			fr := q.frames[c][0]
+			q.frames[c][0] = nil
+			q.frames[c] = q.frames[c][1:]
+			return fr, c
+		}
+	}
+	return nil, 0
+}
+
+func (q *queue) len() int {
+	n := 0
+	for c := range q.frames {
+		n += len(q.frames[c])
+	}
+	return n
+}
+
+type shapedChannel struct {
+	channel  *gomavlib.Channel
+	rate     int
+	cancel   func()
+	notify   chan struct{}
+	queue    queue
+	reported [classCount]uint64
+
+	lastRadioStatus time.Time
+	txBuf           uint8
+	delay           time.Duration
+	lastLowSent     time.Time
+}
+
+func (sc *shapedChannel) wake() {
+	select {
+	case sc.notify <- struct{}{}:
+	default:
+	}
+}
+
+// ChannelStats are the statistics of a shaped channel.
+type ChannelStats struct {
+	Channel string
+	Queued  [classCount]int
+	Dropped [classCount]uint64
+
+	// free space of the radio buffer, in percentage. It is -1 when unknown.
+	TxBuf int
+
+	// delay between low priority frames imposed by flow control.
+	Delay time.Duration
+}
+
+// Shaper limits the bandwidth used by frames sent to specific endpoints.
+// Frames are queued and sent according to their priority class.
+type Shaper struct {
+	Ctx     context.Context
+	Wg      *sync.WaitGroup
+	Budgets []Budget
+
+	// used to compute the size of frames.
+	Traffic *traffic.Accountant
+
+	// frames are queued into the writer once the budget allows it.
+	Writer *writer.Writer
+
+	// maximum number of frames in the queue of each class of each channel.
+	// It defaults to 64.
+	QueueSize int
+
+	// track RADIO_STATUS sent by radios attached to serial endpoints,
+	// and slow down or pause low priority frames when the radio buffer is getting full.
+	// Serial endpoints without a budget are shaped too.
+	FlowControl bool
+
+	Log *slog.Logger
+
+	budgets map[gomavlib.Endpoint]int
+
+	mutex    sync.Mutex
+	channels map[*gomavlib.Channel]*shapedChannel
+}
+
+// Initialize initializes a Shaper.
+func (s *Shaper) Initialize() error {
+	if s.Log == nil {
+		s.Log = slog.Default()
+	}
+
+	if s.QueueSize == 0 {
+		s.QueueSize = defaultQueueSize
+	}
+
+	s.budgets = make(map[gomavlib.Endpoint]int)
+	for _, b := range s.Budgets {
+		s.budgets[b.Endpoint] = b.Rate
+	}
+
+	s.channels = make(map[*gomavlib.Channel]*shapedChannel)
+
+	s.Wg.Add(1)
+	go s.run()
+
+	return nil
+}
+
+func (s *Shaper) run() {
+	defer s.Wg.Done()
+
+	ticker := time.NewTicker(reportPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.report()
+
+		case <-s.Ctx.Done():
+			return
+		}
+	}
+}
+
+// report logs frames that have been dropped since the last report.
+func (s *Shaper) report() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, sc := range s.channels {
+		attrs := []any{logger.Channel(sc.channel)}
+		total := uint64(0)
+
+		for c := Class(0); c < classCount; c++ {
+			n := sc.queue.dropped[c] - sc.reported[c]
+			if n != 0 {
+				attrs = append(attrs, slog.Uint64(c.String(), n))
+				total += n
+			}
+		}
+
+		sc.reported = sc.queue.dropped
+
+		if total != 0 {
+			s.Log.Warn("bandwidth budget exceeded, frames dropped", attrs...)
+		}
+	}
+}
+
+// eligibleClass returns the lowest priority class that can be sent,
+// and, when some classes are held back, the time after which eligibility must be checked again.
+func (s *Shaper) eligibleClass(sc *shapedChannel, now time.Time) (Class, time.Duration) {
+	if !s.FlowControl || sc.lastRadioStatus.IsZero() {
+		return ClassBulk, 0
+	}
+
+	// flow control is suspended when the radio stops sending RADIO_STATUS
+	sinceStatus := now.Sub(sc.lastRadioStatus)
+	if sinceStatus >= radioStatusTimeout {
+		return ClassBulk, 0
+	}
+
+	if sc.txBuf < pauseTxBuf {
+		return ClassParameters, radioStatusTimeout - sinceStatus
+	}
+
+	if sinceLow := now.Sub(sc.lastLowSent); sinceLow < sc.delay {
+		return ClassParameters, sc.delay - sinceLow
+	}
+
+	return ClassBulk, 0
+}
+
+func (s *Shaper) runChannel(ctx context.Context, sc *shapedChannel) {
+	defer s.Wg.Done()
+
+	burst := max(float64(sc.rate)*burstPeriod.Seconds(), maxFrameSize)
+	tokens := burst
+	last := timeNow()
+
+	refill := func() {
+		now := timeNow()
+		tokens = min(tokens+now.Sub(last).Seconds()*float64(sc.rate), burst)
+		last = now
+	}
+
+	for {
+		// wait for the budget to be available. The budget can be overdrawn by a single frame,
+		// therefore frames are never delayed more than needed.
+		if sc.rate != 0 {
+			refill()
+
+			if tokens < 0 {
+				timer := time.NewTimer(time.Duration(-tokens / float64(sc.rate) * float64(time.Second)))
+
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+					timer.Stop()
+					return
+				}
+
+				refill()
+			}
+		}
+
+		// take the frame with the highest priority after waiting,
+		// in order to let it overtake frames that were queued in the meanwhile.
+		var fr frame.Frame
+
+		for {
+			now := timeNow()
+
+			s.mutex.Lock()
+			maxClass, recheck := s.eligibleClass(sc, now)
+			var c Class
+			fr, c = sc.queue.pop(maxClass)
+			if fr != nil && c >= ClassTelemetry {
+				sc.lastLowSent = now
+			}
+			s.mutex.Unlock()
+
+			if fr != nil {
+				break
+			}
+
+			var timer *time.Timer
+			var timerC <-chan time.Time
+			if recheck > 0 {
+				timer = time.NewTimer(recheck)
+				timerC = timer.C
+			}
+
+			select {
+			case <-sc.notify:
+			case <-timerC:
+			case <-ctx.Done():
+				return
+			}
+
+			if timer != nil {
+				timer.Stop()
+			}
+		}
+
+		if sc.rate != 0 {
+			tokens -= float64(s.Traffic.FrameSize(fr))
+		}
+
+		writeFrame(s.Writer, sc.channel, fr)
+	}
+}
+
+// Egress filters the channels a frame is about to be sent to,
+// removing and queueing the ones that are shaped.
+func (s *Shaper) Egress(fr frame.Frame, targets []*gomavlib.Channel) []*gomavlib.Channel {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ret := make([]*gomavlib.Channel, 0, len(targets))
+
+	for _, ch := range targets {
+		sc, ok := s.channels[ch]
+		if !ok {
+			ret = append(ret, ch)
+			continue
+		}
+
+		sc.queue.push(fr)
+		sc.wake()
+	}
+
+	return ret
+}
+
+// ProcessFrame processes a EventFrame.
+func (s *Shaper) ProcessFrame(evt *gomavlib.EventFrame) {
+	if !s.FlowControl {
+		return
+	}
+
+	msg, ok := evt.Message().(*common.MessageRadioStatus)
+	if !ok {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sc, ok := s.channels[evt.Channel]
+	if !ok {
+		return
+	}
+
+	sc.lastRadioStatus = timeNow()
+	sc.txBuf = msg.Txbuf
+
+	switch {
+	case msg.Txbuf < slowTxBuf:
+		sc.delay = min(sc.delay+delayStep, maxDelay)
+
+	case msg.Txbuf > fastTxBuf:
+		sc.delay /= 2
+		if sc.delay < delayStep {
+			sc.delay = 0
+		}
+	}
+
+	sc.wake()
+}
+
+// ProcessChannelOpen processes a EventChannelOpen.
+func (s *Shaper) ProcessChannelOpen(evt *gomavlib.EventChannelOpen) {
+	e := channelEndpoint(evt.Channel)
+
+	rate, ok := s.budgets[e]
+	if !ok {
+		if _, isSerial := e.(*gomavlib.EndpointSerial); !isSerial || !s.FlowControl {
+			return
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sc := &shapedChannel{
+		channel: evt.Channel,
+		rate:    rate,
+		notify:  make(chan struct{}, 1),
+		queue:   queue{size: s.QueueSize},
+	}
+	s.channels[evt.Channel] = sc
+
+	var ctx context.Context
+	ctx, sc.cancel = context.WithCancel(s.Ctx)
+
+	s.Wg.Add(1)
+	go s.runChannel(ctx, sc)
+}
+
+// ProcessChannelClose processes a EventChannelClose.
+func (s *Shaper) ProcessChannelClose(evt *gomavlib.EventChannelClose) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sc, ok := s.channels[evt.Channel]
+	if !ok {
+		return
+	}
+
+	sc.cancel()
+	delete(s.channels, evt.Channel)
+}
+
+// Stats returns the statistics of shaped channels.
+func (s *Shaper) Stats() []ChannelStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ret := make([]ChannelStats, 0, len(s.channels))
+
+	for _, sc := range s.channels {
+		st := ChannelStats{
+			Channel: sc.channel.String(),
+			Dropped: sc.queue.dropped,
+			TxBuf:   -1,
+			Delay:   sc.delay,
+		}
+		if !sc.lastRadioStatus.IsZero() {
+			st.TxBuf = int(sc.txBuf)
+		}
+		for c := range sc.queue.frames {
+			st.Queued[c] = len(sc.queue.frames[c])
+		}
+		ret = append(ret, st)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Channel < ret[j].Channel
+	})
+
+	return ret
+}
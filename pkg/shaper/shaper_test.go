@@ -0,0 +1,213 @@
+package shaper
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gomavlib/v4"
+	"github.com/bluenviron/gomavlib/v4/pkg/dialects/common"
+	"github.com/bluenviron/gomavlib/v4/pkg/frame"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bluenviron/mavp2p/pkg/traffic"
+	"github.com/bluenviron/mavp2p/pkg/writer"
+)
+
+func TestQueue(t *testing.T) {
+	q := queue{size: 2}
+
+	attitude := func(seq byte) frame.Frame {
+		return &frame.V2Frame{SequenceNumber: seq, Message: &common.MessageAttitude{}}
+	}
+	ack := func(seq byte) frame.Frame {
+		return &frame.V2Frame{SequenceNumber: seq, Message: &common.MessageCommandAck{}}
+	}
+	param := func(seq byte) frame.Frame {
+		return &frame.V2Frame{SequenceNumber: seq, Message: &common.MessageParamValue{}}
+	}
+
+	q.push(attitude(0))
+	q.push(attitude(1))
+	q.push(attitude(2))
+	q.push(param(3))
+	q.push(ack(4))
+	q.push(ack(5))
+	q.push(ack(6))
+
+	require.Equal(t, 5, q.len())
+	require.Equal(t, [classCount]uint64{1, 0, 1, 0}, q.dropped)
+
+	// higher priority classes are sent first, telemetry discards the oldest frames,
+	// other classes discard new frames.
+	fr, c := q.pop(ClassParameters)
+	require.Equal(t, byte(4), fr.GetSequenceNumber())
+	require.Equal(t, ClassControl, c)
+
+	var seqs []byte
+	for fr, _ := q.pop(ClassBulk); fr != nil; fr, _ = q.pop(ClassBulk) {
+		seqs = append(seqs, fr.GetSequenceNumber())
+	}
+	require.Equal(t, []byte{5, 3, 1, 2}, seqs)
+
+	// low priority classes can be held back
+	q.push(attitude(7))
+	fr, _ = q.pop(ClassParameters)
+	require.Nil(t, fr)
+}
+
+func TestShaper(t *testing.T) {
+	radio := &gomavlib.EndpointSerial{}
+	udp := &gomavlib.EndpointUDPServer{}
+	radioCh := &gomavlib.Channel{}
+	udpCh := &gomavlib.Channel{}
+
+	channelEndpoints := map[*gomavlib.Channel]gomavlib.Endpoint{
+		radioCh: radio,
+		udpCh:   udp,
+	}
+	channelEndpoint = func(ch *gomavlib.Channel) gomavlib.Endpoint {
+		return channelEndpoints[ch]
+	}
+	defer func() { channelEndpoint = func(ch *gomavlib.Channel) gomavlib.Endpoint { return ch.Endpoint() } }()
+
+	written := make(chan frame.Frame, 10)
+	writeFrame = func(_ *writer.Writer, ch *gomavlib.Channel, fr frame.Frame) {
+		require.Equal(t, radioCh, ch)
+		written <- fr
+	}
+	defer func() {
+		writeFrame = func(w *writer.Writer, ch *gomavlib.Channel, fr frame.Frame) {
+			w.Write(ch, fr)
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	tr := &traffic.Accountant{
+		Ctx: ctx,
+		Wg:  &wg,
+	}
+	err := tr.Initialize()
+	require.NoError(t, err)
+
+	s := &Shaper{
+		Ctx:     ctx,
+		Wg:      &wg,
+		Budgets: []Budget{{Endpoint: radio, Rate: 1000}},
+		Traffic: tr,
+	}
+	err = s.Initialize()
+	require.NoError(t, err)
+
+	s.ProcessChannelOpen(&gomavlib.EventChannelOpen{Channel: radioCh})
+	s.ProcessChannelOpen(&gomavlib.EventChannelOpen{Channel: udpCh})
+
+	// frames of other endpoints are not shaped
+	fr := &frame.V2Frame{Message: &common.MessageAttitude{}}
+	require.Equal(t, []*gomavlib.Channel{udpCh}, s.Egress(fr, []*gomavlib.Channel{radioCh, udpCh}))
+
+	require.Equal(t, fr, <-written)
+
+	// the budget is exhausted by a burst of frames, then frames are delayed
+	start := time.Now()
+	for i := 0; i < 30; i++ {
+		s.Egress(&frame.V2Frame{Message: &common.MessageAttitude{}}, []*gomavlib.Channel{radioCh})
+	}
+	for i := 0; i < 30; i++ {
+		<-written
+	}
+	require.Greater(t, time.Since(start), 50*time.Millisecond)
+
+	require.Equal(t, []ChannelStats{{Channel: radioCh.String(), TxBuf: -1}}, s.Stats())
+
+	s.ProcessChannelClose(&gomavlib.EventChannelClose{Channel: radioCh})
+	require.Empty(t, s.Stats())
+
+	cancel()
+	wg.Wait()
+}
+
+func TestShaperFlowControl(t *testing.T) {
+	radio := &gomavlib.EndpointSerial{}
+	radioCh := &gomavlib.Channel{}
+
+	channelEndpoint = func(_ *gomavlib.Channel) gomavlib.Endpoint {
+		return radio
+	}
+	defer func() { channelEndpoint = func(ch *gomavlib.Channel) gomavlib.Endpoint { return ch.Endpoint() } }()
+
+	written := make(chan frame.Frame, 10)
+	writeFrame = func(_ *writer.Writer, _ *gomavlib.Channel, fr frame.Frame) {
+		written <- fr
+	}
+	defer func() {
+		writeFrame = func(w *writer.Writer, ch *gomavlib.Channel, fr frame.Frame) {
+			w.Write(ch, fr)
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	tr := &traffic.Accountant{
+		Ctx: ctx,
+		Wg:  &wg,
+	}
+	err := tr.Initialize()
+	require.NoError(t, err)
+
+	s := &Shaper{
+		Ctx:         ctx,
+		Wg:          &wg,
+		Traffic:     tr,
+		FlowControl: true,
+	}
+	err = s.Initialize()
+	require.NoError(t, err)
+
+	// serial endpoints are shaped even without a budget
+	s.ProcessChannelOpen(&gomavlib.EventChannelOpen{Channel: radioCh})
+	require.Len(t, s.Stats(), 1)
+
+	radioStatus := func(txBuf uint8) {
+		s.ProcessFrame(&gomavlib.EventFrame{
+			Frame: &frame.V1Frame{
+				SystemID:    51,
+				ComponentID: 68,
+				Message:     &common.MessageRadioStatus{Txbuf: txBuf},
+			},
+			Channel: radioCh,
+		})
+	}
+
+	// the radio buffer is almost full, telemetry is paused
+	radioStatus(10)
+	require.Equal(t, 10, s.Stats()[0].TxBuf)
+	require.Equal(t, 20*time.Millisecond, s.Stats()[0].Delay)
+
+	telemetry := &frame.V2Frame{Message: &common.MessageAttitude{}}
+	command := &frame.V2Frame{Message: &common.MessageCommandLong{}}
+
+	s.Egress(telemetry, []*gomavlib.Channel{radioCh})
+	s.Egress(command, []*gomavlib.Channel{radioCh})
+
+	require.Equal(t, command, <-written)
+
+	select {
+	case <-written:
+		t.Error("telemetry should be paused")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// the radio buffer is empty, telemetry is resumed
+	radioStatus(100)
+	require.Equal(t, time.Duration(0), s.Stats()[0].Delay)
+
+	require.Equal(t, telemetry, <-written)
+
+	cancel()
+	wg.Wait()
+}
@@ -0,0 +1,761 @@
+// Package streamconf contains the stream configurator.
+package streamconf
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gomavlib/v4"
+	"github.com/bluenviron/gomavlib/v4/pkg/dialects/common"
+	"github.com/bluenviron/gomavlib/v4/pkg/frame"
+	"github.com/bluenviron/gomavlib/v4/pkg/message"
+
+	"github.com/bluenviron/mavp2p/pkg/logger"
+	"github.com/bluenviron/mavp2p/pkg/messageman"
+)
+
+const (
+	defaultAckTimeout   = 1 * time.Second
+	defaultRetries      = 3
+	defaultVerifyPeriod = 10 * time.Second
+
+	// autopilots that do not send heartbeats within this period
+	// are considered rebooted, and intervals are applied again.
+	lostTimeout = 10 * time.Second
+
+	// observed rates that differ from requested ones by more than this ratio are reported.
+	rateTolerance = 0.5
+
+	// when arbitration is enabled, REQUEST_MESSAGE commands that are received within this window
+	// from the forwarding of an identical one, sent by the same requester, are answered by the router.
+	requestMessageWindow = 1 * time.Second
+)
+
+var (
+	timeNow        = time.Now
+	writeMessageTo = func(m *messageman.Manager, ch *gomavlib.Channel, msg message.Message) error {
+		return m.WriteMessageTo(ch, msg)
+	}
+	writeFrameTo = func(m *messageman.Manager, ch *gomavlib.Channel, fr frame.Frame) error {
+		return m.WriteFrameTo(ch, fr)
+	}
+)
+
+// Interval is the requested rate of a message.
+type Interval struct {
+	MessageID uint32
+	Name      string
+
+	// rate in Hz. Zero disables the message.
+	Rate float64
+}
+
+// intervalParam returns the interval parameter of MAV_CMD_SET_MESSAGE_INTERVAL.
+func intervalParam(rate float64, isSet bool) float32 {
+	switch {
+	case !isSet:
+		return 0
+	case rate == 0:
+		return -1
+	}
+	return float32(math.Round(1e6 / rate))
+}
+
+// Result is the result of a SET_MESSAGE_INTERVAL command.
+type Result int
+
+// results.
+const (
+	ResultPending Result = iota
+	ResultAccepted
+	ResultRejected
+	ResultNoAck
+)
+
+var resultLabels = map[Result]string{
+	ResultPending:  "pending",
+	ResultAccepted: "accepted",
+	ResultRejected: "rejected",
+	ResultNoAck:    "no_ack",
+}
+
+// String implements fmt.Stringer.
+func (r Result) String() string {
+	return resultLabels[r]
+}
+
+// MessageStatus is the status of the interval of a message.
+type MessageStatus struct {
+	MessageID    uint32
+	Name         string
+	Requested    float64
+	Observed     float64
+	Result       Result
+	AckedCommand common.MAV_RESULT
+}
+
+// TargetStatus is the status of the intervals of an autopilot.
+type TargetStatus struct {
+	Channel     string
+	SystemID    byte
+	ComponentID byte
+	Applied     time.Time
+	Messages    []MessageStatus
+}
+
+type targetKey struct {
+	channel     *gomavlib.Channel
+	systemID    byte
+	componentID byte
+}
+
+func (k targetKey) logAttrs() []any {
+	return []any{logger.Channel(k.channel), logger.SystemID(k.systemID), logger.ComponentID(k.componentID)}
+}
+
+// requesterKey is a ground station that requested message intervals.
+type requesterKey struct {
+	channel     *gomavlib.Channel
+	systemID    byte
+	componentID byte
+}
+
+// requestKey identifies a REQUEST_MESSAGE command.
+type requestKey struct {
+	requester requesterKey
+	params    [7]float64
+}
+
+type messageState struct {
+	rate      float64
+	result    Result
+	ackResult common.MAV_RESULT
+}
+
+type target struct {
+	key           targetKey
+	cancel        func()
+	lastHeartbeat time.Time
+	systemStatus  common.MAV_STATE
+	applied       time.Time
+	pending       bool
+	ack           chan common.MAV_RESULT
+	wake          chan struct{}
+	queue         []uint32
+	messages      map[uint32]*messageState
+	requests      map[requesterKey]map[uint32]float64
+	lastRequested map[requestKey]time.Time
+	counts        map[uint32]uint64
+	observed      map[uint32]float64
+	countsStart   time.Time
+}
+
+// Configurator is a stream configurator.
+// It sets the interval of messages emitted by autopilots with MAV_CMD_SET_MESSAGE_INTERVAL.
+type Configurator struct {
+	Ctx          context.Context
+	Wg           *sync.WaitGroup
+	MessageMan   *messageman.Manager
+	SystemID     byte
+	ComponentID  byte
+	Intervals    []Interval
+	AckTimeout   time.Duration // defaults to 1 second
+	Retries      int           // defaults to 3
+	VerifyPeriod time.Duration // defaults to 10 seconds
+
+	// intercept MAV_CMD_SET_MESSAGE_INTERVAL commands sent by ground stations,
+	// merge them by taking the maximum requested rate of each message
+	// and apply the result to autopilots, answering ground stations on their behalf.
+	// Identical MAV_CMD_REQUEST_MESSAGE commands are forwarded once per second.
+	Arbitrate bool
+
+	Log *slog.Logger
+
+	names map[uint32]string
+
+	mutex   sync.Mutex
+	targets map[targetKey]*target
+}
+
+// Initialize initializes a Configurator.
+func (c *Configurator) Initialize() error {
+	if c.Log == nil {
+		c.Log = slog.Default()
+	}
+
+	if c.AckTimeout == 0 {
+		c.AckTimeout = defaultAckTimeout
+	}
+
+	if c.Retries == 0 {
+		c.Retries = defaultRetries
+	}
+
+	if c.VerifyPeriod == 0 {
+		c.VerifyPeriod = defaultVerifyPeriod
+	}
+
+	c.names = make(map[uint32]string)
+	for _, interval := range c.Intervals {
+		c.names[interval.MessageID] = interval.Name
+	}
+
+	c.targets = make(map[targetKey]*target)
+
+	c.Wg.Add(1)
+	go c.run()
+
+	return nil
+}
+
+func (c *Configurator) run() {
+	defer c.Wg.Done()
+
+	ticker := time.NewTicker(c.VerifyPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.verify()
+
+		case <-c.Ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *Configurator) messageName(id uint32) string {
+	if name, ok := c.names[id]; ok {
+		return name
+	}
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+// verify compares observed rates with requested ones.
+func (c *Configurator) verify() {
+	now := timeNow()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, t := range c.targets {
+		elapsed := now.Sub(t.countsStart).Seconds()
+		if elapsed <= 0 {
+			continue
+		}
+
+		for id, m := range t.messages {
+			observed := float64(t.counts[id]) / elapsed
+			t.observed[id] = observed
+
+			if m.result != ResultAccepted {
+				continue
+			}
+
+			if math.Abs(observed-m.rate) > m.rate*rateTolerance {
+				c.Log.Warn("observed rate differs from requested rate", append(t.key.logAttrs(),
+					slog.String("message", c.messageName(id)),
+					slog.Float64("requested", m.rate),
+					slog.Float64("observed", math.Round(observed*10)/10))...)
+			}
+		}
+
+		clear(t.counts)
+		t.countsStart = now
+	}
+}
+
+// desiredRate returns the maximum rate of a message among the configured one
+// and the ones requested by ground stations.
+func (c *Configurator) desiredRate(t *target, id uint32) (float64, bool) {
+	rate := 0.0
+	isSet := false
+
+	for _, interval := range c.Intervals {
+		if interval.MessageID == id {
+			rate = interval.Rate
+			isSet = true
+		}
+	}
+
+	for _, requests := range t.requests {
+		if r, ok := requests[id]; ok && (!isSet || r > rate) {
+			rate = r
+			isSet = true
+		}
+	}
+
+	return rate, isSet
+}
+
+// enqueue schedules the application of the interval of a message.
+func (c *Configurator) enqueue(t *target, id uint32) {
+	for _, queued := range t.queue {
+		if queued == id {
+			return
+		}
+	}
+
+	t.queue = append(t.queue, id)
+
+	select {
+	case t.wake <- struct{}{}:
+	default:
+	}
+}
+
+// enqueueAll schedules the application of all intervals.
+func (c *Configurator) enqueueAll(t *target) {
+	for _, interval := range c.Intervals {
+		c.enqueue(t, interval.MessageID)
+	}
+
+	var requested []uint32
+	for _, requests := range t.requests {
+		for id := range requests {
+			requested = append(requested, id)
+		}
+	}
+	sort.Slice(requested, func(i, j int) bool { return requested[i] < requested[j] })
+
+	for _, id := range requested {
+		c.enqueue(t, id)
+	}
+
+	for _, m := range t.messages {
+		m.result = ResultPending
+		m.ackResult = 0
+	}
+}
+
+// dequeue returns the next message whose interval has to be applied, and its desired rate.
+func (c *Configurator) dequeue(t *target) (uint32, float64, bool, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if len(t.queue) == 0 {
+		return 0, 0, false, false
+	}
+
+	id := t.queue[0]
+	t.queue = t.queue[1:]
+
+	rate, isSet := c.desiredRate(t, id)
+	if isSet {
+		t.messages[id] = &messageState{rate: rate}
+	} else {
+		delete(t.messages, id)
+	}
+
+	return id, rate, isSet, true
+}
+
+// runTarget sends SET_MESSAGE_INTERVAL commands to a target, one at a time,
+// waiting for the COMMAND_ACK of each of them.
+func (c *Configurator) runTarget(ctx context.Context, t *target) {
+	defer c.Wg.Done()
+
+	for {
+		select {
+		case <-t.wake:
+		case <-ctx.Done():
+			return
+		}
+
+		for {
+			id, rate, isSet, ok := c.dequeue(t)
+			if !ok {
+				break
+			}
+
+			c.Log.Debug("setting message interval", append(t.key.logAttrs(),
+				slog.String("message", c.messageName(id)),
+				slog.Float64("rate", rate),
+				slog.Bool("default", !isSet))...)
+
+			res, ackRes := c.applyInterval(ctx, t, id, intervalParam(rate, isSet))
+			if ctx.Err() != nil {
+				return
+			}
+
+			c.mutex.Lock()
+			if m, ok := t.messages[id]; ok && m.rate == rate {
+				m.result = res
+				m.ackResult = ackRes
+			}
+			c.mutex.Unlock()
+
+			if res != ResultAccepted {
+				c.Log.Warn("unable to set message interval", append(t.key.logAttrs(),
+					slog.String("message", c.messageName(id)),
+					slog.String("result", res.String()))...)
+			}
+		}
+	}
+}
+
+// setPending marks whether a SET_MESSAGE_INTERVAL command is waiting for its COMMAND_ACK.
+func (c *Configurator) setPending(t *target, pending bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	t.pending = pending
+
+	// discard acknowledgements that arrived after the timeout of the previous command
+	select {
+	case <-t.ack:
+	default:
+	}
+}
+
+func (c *Configurator) applyInterval(
+	ctx context.Context,
+	t *target,
+	id uint32,
+	interval float32,
+) (Result, common.MAV_RESULT) {
+	c.setPending(t, true)
+	defer c.setPending(t, false)
+
+	for attempt := 0; attempt <= c.Retries; attempt++ {
+		// messages are encoded asynchronously, therefore they cannot be reused.
+		writeMessageTo(c.MessageMan, t.key.channel, &common.MessageCommandLong{ //nolint:errcheck
+			TargetSystem:    t.key.systemID,
+			TargetComponent: t.key.componentID,
+			Command:         common.MAV_CMD_SET_MESSAGE_INTERVAL,
+			Confirmation:    uint8(attempt),
+			Param1:          float32(id),
+			Param2:          interval,
+		})
+
+		timer := time.NewTimer(c.AckTimeout)
+
+		select {
+		case res := <-t.ack:
+			timer.Stop()
+
+			switch res {
+			case common.MAV_RESULT_ACCEPTED:
+				return ResultAccepted, res
+
+			case common.MAV_RESULT_TEMPORARILY_REJECTED, common.MAV_RESULT_IN_PROGRESS:
+				continue
+
+			default:
+				return ResultRejected, res
+			}
+
+		case <-timer.C:
+
+		case <-ctx.Done():
+			timer.Stop()
+			return ResultPending, 0
+		}
+	}
+
+	return ResultNoAck, 0
+}
+
+// ProcessFrame processes a EventFrame.
+// It returns true when the frame has been handled and must not be routed,
+// i.e. when it is an acknowledgement addressed to the router or an arbitrated command.
+func (c *Configurator) ProcessFrame(evt *gomavlib.EventFrame) bool {
+	var ack frame.Frame
+
+	handled := func() bool {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+
+		switch msg := evt.Message().(type) {
+		case *common.MessageHeartbeat:
+			c.processHeartbeat(evt, msg)
+
+		case *common.MessageCommandAck:
+			return c.processCommandAck(evt, msg)
+
+		case *common.MessageCommandLong:
+			if c.Arbitrate {
+				ack = c.arbitrate(evt, msg.TargetSystem, msg.TargetComponent, msg.Command, [7]float64{
+					float64(msg.Param1), float64(msg.Param2), float64(msg.Param3), float64(msg.Param4),
+					float64(msg.Param5), float64(msg.Param6), float64(msg.Param7),
+				})
+				return ack != nil
+			}
+
+		case *common.MessageCommandInt:
+			if c.Arbitrate {
+				ack = c.arbitrate(evt, msg.TargetSystem, msg.TargetComponent, msg.Command, [7]float64{
+					float64(msg.Param1), float64(msg.Param2), float64(msg.Param3), float64(msg.Param4),
+					float64(msg.X), float64(msg.Y), float64(msg.Z),
+				})
+				return ack != nil
+			}
+
+		default:
+			if t, ok := c.targets[targetKey{evt.Channel, evt.SystemID(), evt.ComponentID()}]; ok {
+				t.counts[msg.GetID()]++
+			}
+		}
+
+		return false
+	}()
+
+	if ack != nil {
+		writeFrameTo(c.MessageMan, evt.Channel, ack) //nolint:errcheck
+	}
+
+	return handled
+}
+
+func (c *Configurator) processHeartbeat(evt *gomavlib.EventFrame, msg *common.MessageHeartbeat) {
+	if msg.Autopilot == common.MAV_AUTOPILOT_INVALID || msg.Type == common.MAV_TYPE_GCS {
+		return
+	}
+
+	key := targetKey{evt.Channel, evt.SystemID(), evt.ComponentID()}
+	now := timeNow()
+
+	t, ok := c.targets[key]
+	if !ok {
+		t = &target{
+			key:           key,
+			ack:           make(chan common.MAV_RESULT, 1),
+			wake:          make(chan struct{}, 1),
+			messages:      make(map[uint32]*messageState),
+			requests:      make(map[requesterKey]map[uint32]float64),
+			lastRequested: make(map[requestKey]time.Time),
+			counts:        make(map[uint32]uint64),
+			observed:      make(map[uint32]float64),
+		}
+		c.targets[key] = t
+
+		var ctx context.Context
+		ctx, t.cancel = context.WithCancel(c.Ctx)
+
+		c.Wg.Add(1)
+		go c.runTarget(ctx, t)
+	}
+
+	// apply intervals when the autopilot appears, when it comes back after a reboot
+	// and when it completes its boot sequence.
+	booted := t.systemStatus == common.MAV_STATE_BOOT && msg.SystemStatus != common.MAV_STATE_BOOT
+	if !ok || now.Sub(t.lastHeartbeat) >= lostTimeout || booted {
+		c.Log.Info("configuring streams", key.logAttrs()...)
+
+		t.applied = now
+		t.countsStart = now
+		clear(t.counts)
+		c.enqueueAll(t)
+	}
+
+	t.lastHeartbeat = now
+	t.systemStatus = msg.SystemStatus
+}
+
+func (c *Configurator) processCommandAck(evt *gomavlib.EventFrame, msg *common.MessageCommandAck) bool {
+	if msg.Command != common.MAV_CMD_SET_MESSAGE_INTERVAL {
+		return false
+	}
+
+	t, ok := c.targets[targetKey{evt.Channel, evt.SystemID(), evt.ComponentID()}]
+	pending := ok && t.pending
+
+	switch {
+	case msg.TargetSystem == c.SystemID && msg.TargetComponent == c.ComponentID:
+
+	// targets are extensions and are zero in MAVLink v1 frames.
+	// These acknowledgements are taken as answers only while a command is pending,
+	// and are routed otherwise.
+	case msg.TargetSystem == 0 && msg.TargetComponent == 0:
+		if !pending {
+			return false
+		}
+
+	// acknowledgements addressed to ground stations are routed
+	// and are not mistaken for answers to the router's commands.
+	default:
+		return false
+	}
+
+	if pending {
+		select {
+		case t.ack <- msg.Result:
+		default:
+		}
+	}
+
+	return true
+}
+
+// findTarget returns the autopilot a command is addressed to.
+func (c *Configurator) findTarget(systemID byte, componentID byte) *target {
+	for key, t := range c.targets {
+		if key.systemID == systemID && (componentID == 0 || key.componentID == componentID) {
+			return t
+		}
+	}
+	return nil
+}
+
+// arbitrate processes a command sent by a ground station to an autopilot.
+// It returns the acknowledgement to send back, or nil if the command must be routed.
+func (c *Configurator) arbitrate(
+	evt *gomavlib.EventFrame,
+	targetSystem byte,
+	targetComponent byte,
+	command common.MAV_CMD,
+	params [7]float64,
+) frame.Frame {
+	if command != common.MAV_CMD_SET_MESSAGE_INTERVAL && command != common.MAV_CMD_REQUEST_MESSAGE {
+		return nil
+	}
+
+	t := c.findTarget(targetSystem, targetComponent)
+	if t == nil || t.key.systemID == evt.SystemID() {
+		return nil
+	}
+
+	id := uint32(params[0])
+	requester := requesterKey{evt.Channel, evt.SystemID(), evt.ComponentID()}
+
+	if command == common.MAV_CMD_REQUEST_MESSAGE {
+		now := timeNow()
+		key := requestKey{requester, params}
+
+		if now.Sub(t.lastRequested[key]) >= requestMessageWindow {
+			for k, last := range t.lastRequested {
+				if now.Sub(last) >= requestMessageWindow {
+					delete(t.lastRequested, k)
+				}
+			}
+			t.lastRequested[key] = now
+			return nil
+		}
+	} else {
+		prevRate, prevIsSet := c.desiredRate(t, id)
+
+		// zero resets the interval to its default value,
+		// a negative value disables the message.
+		if params[1] == 0 {
+			delete(t.requests[requester], id)
+			if len(t.requests[requester]) == 0 {
+				delete(t.requests, requester)
+			}
+		} else {
+			rate := 0.0
+			if params[1] > 0 {
+				rate = 1e6 / params[1]
+			}
+
+			if t.requests[requester] == nil {
+				t.requests[requester] = make(map[uint32]float64)
+			}
+			t.requests[requester][id] = rate
+		}
+
+		if rate, isSet := c.desiredRate(t, id); rate != prevRate || isSet != prevIsSet {
+			c.enqueue(t, id)
+		}
+	}
+
+	msg := &common.MessageCommandAck{
+		Command:         command,
+		Result:          common.MAV_RESULT_ACCEPTED,
+		TargetSystem:    evt.SystemID(),
+		TargetComponent: evt.ComponentID(),
+	}
+
+	// answer on behalf of the autopilot
+	if _, ok := evt.Frame.(*frame.V2Frame); ok {
+		return &frame.V2Frame{SystemID: t.key.systemID, ComponentID: t.key.componentID, Message: msg}
+	}
+	return &frame.V1Frame{SystemID: t.key.systemID, ComponentID: t.key.componentID, Message: msg}
+}
+
+// ProcessChannelClose processes a EventChannelClose.
+func (c *Configurator) ProcessChannelClose(evt *gomavlib.EventChannelClose) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for key, t := range c.targets {
+		if key.channel == evt.Channel {
+			t.cancel()
+			delete(c.targets, key)
+			continue
+		}
+
+		// drop requests of ground stations that were using the channel
+		for requester, requests := range t.requests {
+			if requester.channel != evt.Channel {
+				continue
+			}
+
+			prev := make(map[uint32]float64)
+			for id := range requests {
+				prev[id], _ = c.desiredRate(t, id)
+			}
+
+			delete(t.requests, requester)
+
+			for id, prevRate := range prev {
+				if rate, _ := c.desiredRate(t, id); rate != prevRate {
+					c.enqueue(t, id)
+				}
+			}
+		}
+	}
+}
+
+// Status returns the status of intervals of all autopilots.
+func (c *Configurator) Status() []TargetStatus {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	ret := make([]TargetStatus, 0, len(c.targets))
+
+	for key, t := range c.targets {
+		s := TargetStatus{
+			Channel:     fmt.Sprint(key.channel),
+			SystemID:    key.systemID,
+			ComponentID: key.componentID,
+			Applied:     t.applied,
+			Messages:    make([]MessageStatus, 0, len(t.messages)),
+		}
+
+		for id, m := range t.messages {
+			s.Messages = append(s.Messages, MessageStatus{
+				MessageID:    id,
+				Name:         c.names[id],
+				Requested:    m.rate,
+				Observed:     t.observed[id],
+				Result:       m.result,
+				AckedCommand: m.ackResult,
+			})
+		}
+
+		sort.Slice(s.Messages, func(i, j int) bool {
+			return s.Messages[i].MessageID < s.Messages[j].MessageID
+		})
+
+		ret = append(ret, s)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].SystemID != ret[j].SystemID {
+			return ret[i].SystemID < ret[j].SystemID
+		}
+		if ret[i].ComponentID != ret[j].ComponentID {
+			return ret[i].ComponentID < ret[j].ComponentID
+		}
+		return ret[i].Channel < ret[j].Channel
+	})
+
+	return ret
+}
@@ -0,0 +1,391 @@
+package streamconf
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gomavlib/v4"
+	"github.com/bluenviron/gomavlib/v4/pkg/dialects/common"
+	"github.com/bluenviron/gomavlib/v4/pkg/frame"
+	"github.com/bluenviron/gomavlib/v4/pkg/message"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bluenviron/mavp2p/pkg/messageman"
+)
+
+func TestConfigurator(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	commands := make(chan *common.MessageCommandLong, 10)
+	writeMessageTo = func(_ *messageman.Manager, _ *gomavlib.Channel, msg message.Message) error {
+		commands <- msg.(*common.MessageCommandLong)
+		return nil
+	}
+	defer func() {
+		writeMessageTo = func(m *messageman.Manager, ch *gomavlib.Channel, msg message.Message) error {
+			return m.WriteMessageTo(ch, msg)
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	c := &Configurator{
+		Ctx:         ctx,
+		Wg:          &wg,
+		SystemID:    125,
+		ComponentID: 191,
+		Intervals: []Interval{
+			{MessageID: 30, Name: "ATTITUDE", Rate: 20},
+			{MessageID: 24, Name: "GPS_RAW_INT", Rate: 0},
+		},
+		AckTimeout:   50 * time.Millisecond,
+		Retries:      1,
+		VerifyPeriod: time.Hour,
+	}
+	err := c.Initialize()
+	require.NoError(t, err)
+
+	ch := &gomavlib.Channel{}
+
+	heartbeat := func(status common.MAV_STATE) {
+		c.ProcessFrame(&gomavlib.EventFrame{
+			Frame: &frame.V2Frame{
+				SystemID:    1,
+				ComponentID: 1,
+				Message: &common.MessageHeartbeat{
+					Type:         common.MAV_TYPE_QUADROTOR,
+					Autopilot:    common.MAV_AUTOPILOT_ARDUPILOTMEGA,
+					SystemStatus: status,
+				},
+			},
+			Channel: ch,
+		})
+	}
+
+	ack := func(result common.MAV_RESULT) bool {
+		return c.ProcessFrame(&gomavlib.EventFrame{
+			Frame: &frame.V2Frame{
+				SystemID:    1,
+				ComponentID: 1,
+				Message: &common.MessageCommandAck{
+					Command:         common.MAV_CMD_SET_MESSAGE_INTERVAL,
+					Result:          result,
+					TargetSystem:    125,
+					TargetComponent: 191,
+				},
+			},
+			Channel: ch,
+		})
+	}
+
+	// heartbeats of ground stations are ignored
+	c.ProcessFrame(&gomavlib.EventFrame{
+		Frame: &frame.V2Frame{
+			SystemID:    255,
+			ComponentID: 190,
+			Message: &common.MessageHeartbeat{
+				Type:      common.MAV_TYPE_GCS,
+				Autopilot: common.MAV_AUTOPILOT_INVALID,
+			},
+		},
+		Channel: ch,
+	})
+	require.Empty(t, c.Status())
+
+	heartbeat(common.MAV_STATE_STANDBY)
+
+	cmd := <-commands
+	require.Equal(t, &common.MessageCommandLong{
+		TargetSystem:    1,
+		TargetComponent: 1,
+		Command:         common.MAV_CMD_SET_MESSAGE_INTERVAL,
+		Param1:          30,
+		Param2:          50000,
+	}, cmd)
+
+	// acknowledgements addressed to ground stations are routed and ignored
+	require.False(t, c.ProcessFrame(&gomavlib.EventFrame{
+		Frame: &frame.V2Frame{
+			SystemID:    1,
+			ComponentID: 1,
+			Message: &common.MessageCommandAck{
+				Command:         common.MAV_CMD_SET_MESSAGE_INTERVAL,
+				Result:          common.MAV_RESULT_DENIED,
+				TargetSystem:    255,
+				TargetComponent: 190,
+			},
+		},
+		Channel: ch,
+	}))
+
+	require.True(t, ack(common.MAV_RESULT_ACCEPTED))
+
+	// the second command is not acknowledged and is sent again
+	cmd = <-commands
+	require.Equal(t, float32(24), cmd.Param1)
+	require.Equal(t, float32(-1), cmd.Param2)
+	require.Equal(t, uint8(0), cmd.Confirmation)
+
+	cmd = <-commands
+	require.Equal(t, float32(24), cmd.Param1)
+	require.Equal(t, uint8(1), cmd.Confirmation)
+
+	require.Eventually(t, func() bool {
+		return c.Status()[0].Messages[0].Result == ResultNoAck
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// observed rates are computed from received frames
+	for i := 0; i < 100; i++ {
+		c.ProcessFrame(&gomavlib.EventFrame{
+			Frame: &frame.V2Frame{
+				SystemID:    1,
+				ComponentID: 1,
+				Message:     &common.MessageAttitude{},
+			},
+			Channel: ch,
+		})
+	}
+
+	now = now.Add(5 * time.Second)
+	c.verify()
+
+	require.Equal(t, []TargetStatus{{
+		Channel:     ch.String(),
+		SystemID:    1,
+		ComponentID: 1,
+		Applied:     time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		Messages: []MessageStatus{
+			{
+				MessageID: 24,
+				Name:      "GPS_RAW_INT",
+				Result:    ResultNoAck,
+			},
+			{
+				MessageID:    30,
+				Name:         "ATTITUDE",
+				Requested:    20,
+				Observed:     20,
+				Result:       ResultAccepted,
+				AckedCommand: common.MAV_RESULT_ACCEPTED,
+			},
+		},
+	}}, c.Status())
+
+	// intervals are applied again after a reboot
+	heartbeat(common.MAV_STATE_BOOT)
+	require.Empty(t, commands)
+
+	heartbeat(common.MAV_STATE_STANDBY)
+	cmd = <-commands
+	require.Equal(t, float32(30), cmd.Param1)
+
+	// MAVLink v1 acknowledgements have no target,
+	// and are answers to the router's commands while one is pending.
+	v1Ack := func() bool {
+		return c.ProcessFrame(&gomavlib.EventFrame{
+			Frame: &frame.V1Frame{
+				SystemID:    1,
+				ComponentID: 1,
+				Message: &common.MessageCommandAck{
+					Command: common.MAV_CMD_SET_MESSAGE_INTERVAL,
+					Result:  common.MAV_RESULT_ACCEPTED,
+				},
+			},
+			Channel: ch,
+		})
+	}
+
+	require.True(t, v1Ack())
+
+	cmd = <-commands
+	require.Equal(t, float32(24), cmd.Param1)
+
+	// they are routed otherwise
+	<-commands
+	require.Eventually(t, func() bool {
+		return !v1Ack()
+	}, 2*time.Second, 10*time.Millisecond)
+
+	cancel()
+	wg.Wait()
+}
+
+func TestConfiguratorArbitrate(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	commands := make(chan *common.MessageCommandLong, 10)
+	writeMessageTo = func(_ *messageman.Manager, _ *gomavlib.Channel, msg message.Message) error {
+		commands <- msg.(*common.MessageCommandLong)
+		return nil
+	}
+	defer func() {
+		writeMessageTo = func(m *messageman.Manager, ch *gomavlib.Channel, msg message.Message) error {
+			return m.WriteMessageTo(ch, msg)
+		}
+	}()
+
+	acks := make(chan frame.Frame, 10)
+	writeFrameTo = func(_ *messageman.Manager, _ *gomavlib.Channel, fr frame.Frame) error {
+		acks <- fr
+		return nil
+	}
+	defer func() {
+		writeFrameTo = func(m *messageman.Manager, ch *gomavlib.Channel, fr frame.Frame) error {
+			return m.WriteFrameTo(ch, fr)
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	c := &Configurator{
+		Ctx:          ctx,
+		Wg:           &wg,
+		SystemID:     125,
+		ComponentID:  191,
+		Intervals:    []Interval{{MessageID: 30, Name: "ATTITUDE", Rate: 10}},
+		AckTimeout:   time.Hour,
+		VerifyPeriod: time.Hour,
+		Arbitrate:    true,
+	}
+	err := c.Initialize()
+	require.NoError(t, err)
+
+	vehicleCh := &gomavlib.Channel{}
+	gcs1Ch := &gomavlib.Channel{}
+	gcs2Ch := &gomavlib.Channel{}
+
+	vehicleAck := func() {
+		c.ProcessFrame(&gomavlib.EventFrame{
+			Frame: &frame.V2Frame{
+				SystemID:    1,
+				ComponentID: 1,
+				Message: &common.MessageCommandAck{
+					Command:         common.MAV_CMD_SET_MESSAGE_INTERVAL,
+					Result:          common.MAV_RESULT_ACCEPTED,
+					TargetSystem:    125,
+					TargetComponent: 191,
+				},
+			},
+			Channel: vehicleCh,
+		})
+	}
+
+	command := func(ch *gomavlib.Channel, systemID byte, cmd common.MAV_CMD, param1 float32, param2 float32) bool {
+		return c.ProcessFrame(&gomavlib.EventFrame{
+			Frame: &frame.V2Frame{
+				SystemID:    systemID,
+				ComponentID: 190,
+				Message: &common.MessageCommandLong{
+					TargetSystem:    1,
+					TargetComponent: 1,
+					Command:         cmd,
+					Param1:          param1,
+					Param2:          param2,
+				},
+			},
+			Channel: ch,
+		})
+	}
+
+	// commands addressed to unknown autopilots are routed
+	require.False(t, command(gcs1Ch, 255, common.MAV_CMD_SET_MESSAGE_INTERVAL, 30, 20000))
+
+	c.ProcessFrame(&gomavlib.EventFrame{
+		Frame: &frame.V2Frame{
+			SystemID:    1,
+			ComponentID: 1,
+			Message: &common.MessageHeartbeat{
+				Type:      common.MAV_TYPE_QUADROTOR,
+				Autopilot: common.MAV_AUTOPILOT_PX4,
+			},
+		},
+		Channel: vehicleCh,
+	})
+
+	cmd := <-commands
+	require.Equal(t, float32(30), cmd.Param1)
+	require.Equal(t, float32(100000), cmd.Param2)
+	vehicleAck()
+
+	// the first ground station requests 50 Hz
+	require.True(t, command(gcs1Ch, 255, common.MAV_CMD_SET_MESSAGE_INTERVAL, 30, 20000))
+	require.Equal(t, &frame.V2Frame{
+		SystemID:    1,
+		ComponentID: 1,
+		Message: &common.MessageCommandAck{
+			Command:         common.MAV_CMD_SET_MESSAGE_INTERVAL,
+			Result:          common.MAV_RESULT_ACCEPTED,
+			TargetSystem:    255,
+			TargetComponent: 190,
+		},
+	}, <-acks)
+
+	cmd = <-commands
+	require.Equal(t, float32(30), cmd.Param1)
+	require.Equal(t, float32(20000), cmd.Param2)
+	vehicleAck()
+
+	// the second ground station requests 5 Hz, that is lower than the merged rate
+	require.True(t, command(gcs2Ch, 254, common.MAV_CMD_SET_MESSAGE_INTERVAL, 30, 200000))
+	<-acks
+
+	// the second ground station requests a different message
+	require.True(t, command(gcs2Ch, 254, common.MAV_CMD_SET_MESSAGE_INTERVAL, 24, 500000))
+	<-acks
+
+	cmd = <-commands
+	require.Equal(t, float32(24), cmd.Param1)
+	require.Equal(t, float32(500000), cmd.Param2)
+	vehicleAck()
+
+	// the first ground station disconnects, the configured rate is restored
+	c.ProcessChannelClose(&gomavlib.EventChannelClose{Channel: gcs1Ch})
+
+	cmd = <-commands
+	require.Equal(t, float32(30), cmd.Param1)
+	require.Equal(t, float32(100000), cmd.Param2)
+	vehicleAck()
+
+	// the second ground station resets the interval of the other message
+	require.True(t, command(gcs2Ch, 254, common.MAV_CMD_SET_MESSAGE_INTERVAL, 24, 0))
+	<-acks
+
+	cmd = <-commands
+	require.Equal(t, float32(24), cmd.Param1)
+	require.Equal(t, float32(0), cmd.Param2)
+	vehicleAck()
+
+	// identical REQUEST_MESSAGE commands are forwarded once
+	require.False(t, command(gcs2Ch, 254, common.MAV_CMD_REQUEST_MESSAGE, 148, 0))
+	require.True(t, command(gcs2Ch, 254, common.MAV_CMD_REQUEST_MESSAGE, 148, 0))
+	<-acks
+
+	// REQUEST_MESSAGE commands with different parameters or requesters are forwarded
+	require.False(t, command(gcs2Ch, 254, common.MAV_CMD_REQUEST_MESSAGE, 148, 1))
+	require.False(t, command(gcs1Ch, 255, common.MAV_CMD_REQUEST_MESSAGE, 148, 0))
+
+	// identical REQUEST_MESSAGE commands are forwarded again after the window
+	now = now.Add(requestMessageWindow)
+	require.False(t, command(gcs2Ch, 254, common.MAV_CMD_REQUEST_MESSAGE, 148, 0))
+
+	// other commands are routed
+	require.False(t, command(gcs2Ch, 254, common.MAV_CMD_COMPONENT_ARM_DISARM, 1, 0))
+
+	require.Eventually(t, func() bool {
+		s := c.Status()
+		return len(s) == 1 && len(s[0].Messages) == 1 && s[0].Messages[0].Result == ResultAccepted
+	}, 2*time.Second, 10*time.Millisecond)
+
+	require.Equal(t, float64(10), c.Status()[0].Messages[0].Requested)
+
+	cancel()
+	wg.Wait()
+}
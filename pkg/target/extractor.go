@@ -0,0 +1,178 @@
+// Package target contains the target extractor.
+package target
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/bluenviron/gomavlib/v4/pkg/dialect"
+	"github.com/bluenviron/gomavlib/v4/pkg/message"
+)
+
+type accessor struct {
+	typ    reflect.Type
+	target func(v reflect.Value) (byte, byte)
+
+	// offsets inside the encoded payload.
+	systemWireOffset    int
+	componentWireOffset int
+}
+
+var enumSizes = map[string]int{
+	"uint8":  1,
+	"int8":   1,
+	"uint16": 2,
+	"int16":  2,
+	"uint32": 4,
+	"int32":  4,
+	"uint64": 8,
+	"int64":  8,
+}
+
+type wireField struct {
+	name      string
+	size      int
+	count     int
+	extension bool
+}
+
+// wireOffsets returns the offsets of fields inside the encoded payload.
+// Fields are sorted by the size of their type, except extensions, that are appended in order.
+func wireOffsets(typ reflect.Type) map[string]int {
+	fields := make([]wireField, 0, typ.NumField())
+
+	for i := range typ.NumField() {
+		f := typ.Field(i)
+		wf := wireField{
+			name:      f.Name,
+			count:     1,
+			extension: f.Tag.Get("mavext") == "true",
+		}
+
+		t := f.Type
+		switch t.Kind() {
+		case reflect.Array:
+			wf.count = t.Len()
+			t = t.Elem()
+
+		case reflect.String:
+			wf.count, _ = strconv.Atoi(f.Tag.Get("mavlen"))
+			wf.size = 1
+		}
+
+		if wf.size == 0 {
+			if enum := f.Tag.Get("mavenum"); enum != "" {
+				wf.size = enumSizes[enum]
+			} else {
+				wf.size = int(t.Size())
+			}
+		}
+
+		fields = append(fields, wf)
+	}
+
+	sort.SliceStable(fields, func(i, j int) bool {
+		if fields[i].extension || fields[j].extension {
+			return !fields[i].extension && fields[j].extension
+		}
+		return fields[i].size > fields[j].size
+	})
+
+	ret := make(map[string]int, len(fields))
+	offset := 0
+	for _, wf := range fields {
+		ret[wf.name] = offset
+		offset += wf.size * wf.count
+	}
+	return ret
+}
+
+// payloadByte returns a byte of an encoded payload.
+// Trailing zeros of payloads may be truncated, therefore missing bytes are zero.
+func payloadByte(payload []byte, offset int) byte {
+	if offset >= len(payload) {
+		return 0
+	}
+	return payload[offset]
+}
+
+func uint8Field(typ reflect.Type, name string) ([]int, bool) {
+	f, ok := typ.FieldByName(name)
+	if !ok || f.Type.Kind() != reflect.Uint8 {
+		return nil, false
+	}
+	return f.Index, true
+}
+
+// Extractor extracts the target system and component of messages.
+// Field accessors are built once from the dialect,
+// therefore extraction does not look up fields of each message.
+type Extractor struct {
+	Dialect *dialect.Dialect
+
+	accessors map[uint32]accessor
+}
+
+// Initialize initializes an Extractor.
+func (e *Extractor) Initialize() error {
+	e.accessors = make(map[uint32]accessor)
+
+	for _, msg := range e.Dialect.Messages {
+		typ := reflect.TypeOf(msg)
+		if typ.Kind() != reflect.Pointer || typ.Elem().Kind() != reflect.Struct {
+			continue
+		}
+
+		systemIndex, ok := uint8Field(typ.Elem(), "TargetSystem")
+		if !ok {
+			continue
+		}
+
+		componentIndex, ok := uint8Field(typ.Elem(), "TargetComponent")
+		if !ok {
+			continue
+		}
+
+		wire := wireOffsets(typ.Elem())
+
+		e.accessors[msg.GetID()] = accessor{
+			typ: typ,
+			target: func(v reflect.Value) (byte, byte) {
+				return byte(v.FieldByIndex(systemIndex).Uint()), byte(v.FieldByIndex(componentIndex).Uint())
+			},
+			systemWireOffset:    wire["TargetSystem"],
+			componentWireOffset: wire["TargetComponent"],
+		}
+	}
+
+	return nil
+}
+
+// HasTarget returns whether a message has the TargetSystem and TargetComponent fields.
+func (e *Extractor) HasTarget(id uint32) bool {
+	_, ok := e.accessors[id]
+	return ok
+}
+
+// Target returns the target system and component of a message.
+// Messages without target are rejected without inspecting their content.
+// Targets of messages that have not been decoded are read from their payload.
+func (e *Extractor) Target(msg message.Message) (byte, byte, bool) {
+	a, ok := e.accessors[msg.GetID()]
+	if !ok {
+		return 0, 0, false
+	}
+
+	if raw, isRaw := msg.(*message.MessageRaw); isRaw {
+		return payloadByte(raw.Payload, a.systemWireOffset), payloadByte(raw.Payload, a.componentWireOffset), true
+	}
+
+	if reflect.TypeOf(msg) != a.typ {
+		return 0, 0, false
+	}
+
+	systemID, componentID := a.target(reflect.ValueOf(msg).Elem())
+
+	return systemID, componentID, true
+}
@@ -0,0 +1,134 @@
+package target
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bluenviron/gomavlib/v4/pkg/dialects/common"
+	"github.com/bluenviron/gomavlib/v4/pkg/message"
+	"github.com/stretchr/testify/require"
+)
+
+// reflectTarget is the previous implementation, that looks up fields of each message.
+func reflectTarget(msg message.Message) (byte, byte, bool) {
+	var zero reflect.Value
+	rv := reflect.ValueOf(msg).Elem()
+	ts := rv.FieldByName("TargetSystem")
+	tc := rv.FieldByName("TargetComponent")
+
+	if ts != zero && tc != zero {
+		return byte(ts.Uint()), byte(tc.Uint()), true
+	}
+
+	return 0, 0, false
+}
+
+func TestExtractor(t *testing.T) {
+	e := &Extractor{Dialect: common.Dialect}
+	err := e.Initialize()
+	require.NoError(t, err)
+
+	for _, ca := range []struct {
+		name      string
+		msg       message.Message
+		system    byte
+		component byte
+		ok        bool
+	}{
+		{
+			"command long",
+			&common.MessageCommandLong{TargetSystem: 1, TargetComponent: 2, Confirmation: 3},
+			1,
+			2,
+			true,
+		},
+		{
+			"command ack",
+			&common.MessageCommandAck{Progress: 5, TargetSystem: 3, TargetComponent: 4},
+			3,
+			4,
+			true,
+		},
+		{
+			"no target",
+			&common.MessageHeartbeat{},
+			0,
+			0,
+			false,
+		},
+		{
+			"raw command long",
+			&message.MessageRaw{ID: 76, Payload: append(make([]byte, 30), 5, 6, 1)},
+			5,
+			6,
+			true,
+		},
+		{
+			"raw command ack",
+			&message.MessageRaw{ID: 77, Payload: append(make([]byte, 8), 7, 8)},
+			7,
+			8,
+			true,
+		},
+		{
+			"raw truncated",
+			&message.MessageRaw{ID: 77, Payload: []byte{1, 2, 3}},
+			0,
+			0,
+			true,
+		},
+		{
+			"raw without target",
+			&message.MessageRaw{ID: 30, Payload: make([]byte, 28)},
+			0,
+			0,
+			false,
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			system, component, ok := e.Target(ca.msg)
+			require.Equal(t, ca.system, system)
+			require.Equal(t, ca.component, component)
+			require.Equal(t, ca.ok, ok)
+
+			if _, isRaw := ca.msg.(*message.MessageRaw); !isRaw {
+				system, component, ok = reflectTarget(ca.msg)
+				require.Equal(t, ca.system, system)
+				require.Equal(t, ca.component, component)
+				require.Equal(t, ca.ok, ok)
+			}
+		})
+	}
+
+	require.True(t, e.HasTarget(76))
+	require.False(t, e.HasTarget(0))
+}
+
+func BenchmarkTarget(b *testing.B) {
+	e := &Extractor{Dialect: common.Dialect}
+	err := e.Initialize()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, ca := range []struct {
+		name string
+		msg  message.Message
+	}{
+		{"with target", &common.MessageCommandLong{TargetSystem: 1, TargetComponent: 2}},
+		{"without target", &common.MessageAttitude{}},
+		{"raw", &message.MessageRaw{ID: 30, Payload: make([]byte, 28)}},
+	} {
+		b.Run(ca.name+"/reflect", func(b *testing.B) {
+			for b.Loop() {
+				reflectTarget(ca.msg)
+			}
+		})
+
+		b.Run(ca.name+"/extractor", func(b *testing.B) {
+			for b.Loop() {
+				e.Target(ca.msg)
+			}
+		})
+	}
+}
@@ -0,0 +1,401 @@
+// Package traffic contains the traffic accountant.
+package traffic
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gomavlib/v4"
+	"github.com/bluenviron/gomavlib/v4/pkg/dialect"
+	"github.com/bluenviron/gomavlib/v4/pkg/frame"
+	"github.com/bluenviron/gomavlib/v4/pkg/message"
+
+	"github.com/bluenviron/mavp2p/pkg/logger"
+)
+
+const (
+	windowSize = 60
+
+	v1HeaderLen     = 6
+	v2HeaderLen     = 10
+	checksumLen     = 2
+	v2SignatureLen  = 13
+	printTopMessage = 3
+
+	// number of frames whose size is kept, in order to encode each decoded message once,
+	// even when its frame is accounted as received, shaped and sent to multiple channels.
+	sizeCacheLen = 1024
+)
+
+var timeNow = time.Now
+
+type bucket struct {
+	second int64
+	frames uint64
+	bytes  uint64
+}
+
+// meter counts frames and bytes in total and in one-second buckets,
+// in order to compute rates over sliding windows.
+type meter struct {
+	frames  uint64
+	bytes   uint64
+	buckets [windowSize]bucket
+}
+
+func (m *meter) add(now time.Time, size int) {
+	m.frames++
+	m.bytes += uint64(size)
+
+	sec := now.Unix()
+	b := &m.buckets[sec%windowSize]
+	if b.second != sec {
+		*b = bucket{second: sec}
+	}
+	b.frames++
+	b.bytes += uint64(size)
+}
+
+// rate returns frames and bytes per second over the last n complete seconds.
+func (m *meter) rate(now time.Time, n int64) (float64, float64) {
+	cur := now.Unix()
+	var frames, bytes uint64
+
+	for i := int64(1); i <= n; i++ {
+		sec := cur - i
+		b := &m.buckets[sec%windowSize]
+		if b.second == sec {
+			frames += b.frames
+			bytes += b.bytes
+		}
+	}
+
+	return float64(frames) / float64(n), float64(bytes) / float64(n)
+}
+
+func (m *meter) counters(now time.Time) Counters {
+	c := Counters{
+		Frames: m.frames,
+		Bytes:  m.bytes,
+	}
+	c.FrameRate1s, c.ByteRate1s = m.rate(now, 1)
+	c.FrameRate10s, c.ByteRate10s = m.rate(now, 10)
+	c.FrameRate60s, c.ByteRate60s = m.rate(now, 60)
+	return c
+}
+
+type messageMeters struct {
+	received meter
+	sent     meter
+}
+
+type channelMeters struct {
+	received meter
+	sent     meter
+	messages map[uint32]*messageMeters
+}
+
+func (c *channelMeters) message(id uint32) *messageMeters {
+	m, ok := c.messages[id]
+	if !ok {
+		m = &messageMeters{}
+		c.messages[id] = m
+	}
+	return m
+}
+
+// Counters are the frames and bytes that passed through a channel,
+// in total and per second over sliding windows of 1, 10 and 60 seconds.
+type Counters struct {
+	Frames       uint64
+	Bytes        uint64
+	FrameRate1s  float64
+	FrameRate10s float64
+	FrameRate60s float64
+	ByteRate1s   float64
+	ByteRate10s  float64
+	ByteRate60s  float64
+}
+
+// MessageStats are the traffic statistics of a message ID.
+type MessageStats struct {
+	ID       uint32
+	Received Counters
+	Sent     Counters
+}
+
+// Stats are the traffic statistics of a channel.
+type Stats struct {
+	Channel  string
+	Received Counters
+	Sent     Counters
+	Messages []MessageStats
+}
+
+// Accountant is a traffic accountant.
+// It counts frames and bytes received and sent by each channel.
+type Accountant struct {
+	Ctx         context.Context
+	Wg          *sync.WaitGroup
+	Dialect     *dialect.Dialect
+	Print       bool
+	PrintPeriod time.Duration
+	Log         *slog.Logger
+
+	dialectRW *dialect.ReadWriter
+
+	mutex    sync.Mutex
+	channels map[*gomavlib.Channel]*channelMeters
+
+	sizesMutex sync.Mutex
+	sizes      map[frame.Frame]int
+	sizesOrder [sizeCacheLen]frame.Frame
+	sizesNext  int
+}
+
+// Initialize initializes an Accountant.
+func (a *Accountant) Initialize() error {
+	if a.Log == nil {
+		a.Log = slog.Default()
+	}
+
+	if a.Dialect != nil {
+		a.dialectRW = &dialect.ReadWriter{Dialect: a.Dialect}
+		err := a.dialectRW.Initialize()
+		if err != nil {
+			return err
+		}
+	}
+
+	a.channels = make(map[*gomavlib.Channel]*channelMeters)
+	a.sizes = make(map[frame.Frame]int)
+
+	a.Wg.Add(1)
+	go a.run()
+
+	return nil
+}
+
+func (a *Accountant) run() {
+	defer a.Wg.Done()
+
+	if !a.Print {
+		return
+	}
+
+	ticker := time.NewTicker(a.PrintPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			a.printStats()
+
+		case <-a.Ctx.Done():
+			return
+		}
+	}
+}
+
+func (a *Accountant) printStats() {
+	for _, s := range a.Stats() {
+		a.Log.Info("traffic",
+			slog.String(logger.FieldChannel, s.Channel),
+			slog.Float64("rx_frames_per_sec", round(s.Received.FrameRate10s)),
+			slog.Float64("rx_bytes_per_sec", round(s.Received.ByteRate10s)),
+			slog.Float64("tx_frames_per_sec", round(s.Sent.FrameRate10s)),
+			slog.Float64("tx_bytes_per_sec", round(s.Sent.ByteRate10s)),
+			slog.String("top_rx", topMessages(s.Messages, func(m MessageStats) float64 { return m.Received.ByteRate10s })),
+			slog.String("top_tx", topMessages(s.Messages, func(m MessageStats) float64 { return m.Sent.ByteRate10s })))
+	}
+}
+
+func round(v float64) float64 {
+	return float64(int64(v*10+0.5)) / 10
+}
+
+// topMessages returns the message IDs that use most bandwidth,
+// in the id:bytes_per_sec format.
+func topMessages(msgs []MessageStats, rate func(MessageStats) float64) string {
+	sorted := make([]MessageStats, 0, len(msgs))
+	for _, m := range msgs {
+		if rate(m) > 0 {
+			sorted = append(sorted, m)
+		}
+	}
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return rate(sorted[i]) > rate(sorted[j])
+	})
+
+	if len(sorted) > printTopMessage {
+		sorted = sorted[:printTopMessage]
+	}
+
+	parts := make([]string, len(sorted))
+	for i, m := range sorted {
+		parts[i] = strconv.FormatUint(uint64(m.ID), 10) + ":" + strconv.FormatFloat(round(rate(m)), 'f', -1, 64)
+	}
+	return strings.Join(parts, ",")
+}
+
+// FrameSize returns the size of a frame on the wire.
+// Decoded messages are encoded once per frame, then their size is reused.
+func (a *Accountant) FrameSize(fr frame.Frame) int {
+	var size int
+	isV2 := false
+
+	switch fr := fr.(type) {
+	case *frame.V1Frame:
+		size = v1HeaderLen + checksumLen
+
+	case *frame.V2Frame:
+		size = v2HeaderLen + checksumLen
+		if fr.IsSigned() {
+			size += v2SignatureLen
+		}
+		isV2 = true
+	}
+
+	msg := fr.GetMessage()
+
+	if raw, ok := msg.(*message.MessageRaw); ok {
+		return size + len(raw.Payload)
+	}
+
+	if a.dialectRW == nil {
+		return size
+	}
+
+	return size + a.payloadSize(fr, isV2)
+}
+
+// payloadSize returns the size of the encoded payload of a decoded message.
+func (a *Accountant) payloadSize(fr frame.Frame, isV2 bool) int {
+	a.sizesMutex.Lock()
+	n, ok := a.sizes[fr]
+	a.sizesMutex.Unlock()
+
+	if ok {
+		return n
+	}
+
+	msg := fr.GetMessage()
+	if mrw := a.dialectRW.GetMessage(msg.GetID()); mrw != nil {
+		if raw := mrw.Write(msg, isV2); raw != nil {
+			n = len(raw.Payload)
+		}
+	}
+
+	a.sizesMutex.Lock()
+	defer a.sizesMutex.Unlock()
+
+	if _, ok := a.sizes[fr]; !ok {
+		if old := a.sizesOrder[a.sizesNext]; old != nil {
+			delete(a.sizes, old)
+		}
+		a.sizesOrder[a.sizesNext] = fr
+		a.sizesNext = (a.sizesNext + 1) % sizeCacheLen
+		a.sizes[fr] = n
+	}
+
+	return n
+}
+
+func (a *Accountant) channel(ch *gomavlib.Channel) *channelMeters {
+	c, ok := a.channels[ch]
+	if !ok {
+		c = &channelMeters{
+			messages: make(map[uint32]*messageMeters),
+		}
+		a.channels[ch] = c
+	}
+	return c
+}
+
+// ProcessReceived accounts a frame received from a channel.
+func (a *Accountant) ProcessReceived(ch *gomavlib.Channel, fr frame.Frame) {
+	size := a.FrameSize(fr)
+	now := timeNow()
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	c := a.channel(ch)
+	c.received.add(now, size)
+	c.message(fr.GetMessage().GetID()).received.add(now, size)
+}
+
+// ProcessSent accounts a frame sent to a channel.
+func (a *Accountant) ProcessSent(ch *gomavlib.Channel, fr frame.Frame) {
+	size := a.FrameSize(fr)
+	now := timeNow()
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	c := a.channel(ch)
+	c.sent.add(now, size)
+	c.message(fr.GetMessage().GetID()).sent.add(now, size)
+}
+
+// ProcessChannelOpen processes a EventChannelOpen.
+func (a *Accountant) ProcessChannelOpen(evt *gomavlib.EventChannelOpen) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.channel(evt.Channel)
+}
+
+// ProcessChannelClose processes a EventChannelClose.
+func (a *Accountant) ProcessChannelClose(evt *gomavlib.EventChannelClose) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	delete(a.channels, evt.Channel)
+}
+
+// Stats returns the traffic statistics of all open channels.
+func (a *Accountant) Stats() []Stats {
+	now := timeNow()
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	ret := make([]Stats, 0, len(a.channels))
+
+	for ch, c := range a.channels {
+		s := Stats{
+			Channel:  fmt.Sprint(ch),
+			Received: c.received.counters(now),
+			Sent:     c.sent.counters(now),
+			Messages: make([]MessageStats, 0, len(c.messages)),
+		}
+
+		for id, m := range c.messages {
+			s.Messages = append(s.Messages, MessageStats{
+				ID:       id,
+				Received: m.received.counters(now),
+				Sent:     m.sent.counters(now),
+			})
+		}
+
+		sort.Slice(s.Messages, func(i, j int) bool {
+			return s.Messages[i].ID < s.Messages[j].ID
+		})
+
+		ret = append(ret, s)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Channel < ret[j].Channel
+	})
+
+	return ret
+}
@@ -0,0 +1,153 @@
+package traffic
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gomavlib/v4"
+	"github.com/bluenviron/gomavlib/v4/pkg/dialect"
+	"github.com/bluenviron/gomavlib/v4/pkg/dialects/common"
+	"github.com/bluenviron/gomavlib/v4/pkg/frame"
+	"github.com/bluenviron/gomavlib/v4/pkg/message"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccountant(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	a := &Accountant{
+		Ctx: ctx,
+		Wg:  &wg,
+	}
+	err := a.Initialize()
+	require.NoError(t, err)
+
+	ch1 := &gomavlib.Channel{}
+	ch2 := &gomavlib.Channel{}
+
+	v1 := &frame.V1Frame{
+		Message: &message.MessageRaw{ID: 0, Payload: make([]byte, 9)},
+	}
+	v2 := &frame.V2Frame{
+		Message: &message.MessageRaw{ID: 33, Payload: make([]byte, 20)},
+	}
+
+	// 10 seconds of traffic, with a V1 frame received and a V2 frame
+	// received and sent every second.
+	for i := 0; i < 10; i++ {
+		a.ProcessReceived(ch1, v1)
+		a.ProcessReceived(ch1, v2)
+		a.ProcessSent(ch2, v2)
+		now = now.Add(time.Second)
+	}
+
+	stats := a.Stats()
+	require.Len(t, stats, 2)
+
+	var rx, tx Stats
+	for _, s := range stats {
+		if s.Received.Frames != 0 {
+			rx = s
+		} else {
+			tx = s
+		}
+	}
+
+	require.Equal(t, uint64(20), rx.Received.Frames)
+	require.Equal(t, uint64(10*(17+32)), rx.Received.Bytes)
+	require.Equal(t, 2.0, rx.Received.FrameRate1s)
+	require.Equal(t, 2.0, rx.Received.FrameRate10s)
+	require.Equal(t, 20.0/60, rx.Received.FrameRate60s)
+	require.Equal(t, float64(17+32), rx.Received.ByteRate10s)
+	require.Equal(t, []MessageStats{
+		{
+			ID: 0,
+			Received: Counters{
+				Frames:       10,
+				Bytes:        170,
+				FrameRate1s:  1,
+				FrameRate10s: 1,
+				FrameRate60s: 10.0 / 60,
+				ByteRate1s:   17,
+				ByteRate10s:  17,
+				ByteRate60s:  170.0 / 60,
+			},
+		},
+		{
+			ID: 33,
+			Received: Counters{
+				Frames:       10,
+				Bytes:        320,
+				FrameRate1s:  1,
+				FrameRate10s: 1,
+				FrameRate60s: 10.0 / 60,
+				ByteRate1s:   32,
+				ByteRate10s:  32,
+				ByteRate60s:  320.0 / 60,
+			},
+		},
+	}, rx.Messages)
+
+	require.Equal(t, uint64(10), tx.Sent.Frames)
+	require.Equal(t, uint64(320), tx.Sent.Bytes)
+	require.Equal(t, "33:32", topMessages(tx.Messages, func(m MessageStats) float64 { return m.Sent.ByteRate10s }))
+
+	// rates decay when traffic stops
+	now = now.Add(5 * time.Second)
+	stats = a.Stats()
+	for _, s := range stats {
+		if s.Received.Frames != 0 {
+			require.Equal(t, 0.0, s.Received.FrameRate1s)
+			require.Equal(t, 1.0, s.Received.FrameRate10s)
+		}
+	}
+
+	a.ProcessChannelClose(&gomavlib.EventChannelClose{Channel: ch1})
+	require.Len(t, a.Stats(), 1)
+
+	cancel()
+	wg.Wait()
+}
+
+func TestAccountantFrameSize(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	a := &Accountant{
+		Ctx:     ctx,
+		Wg:      &wg,
+		Dialect: common.Dialect,
+	}
+	err := a.Initialize()
+	require.NoError(t, err)
+
+	rw := &dialect.ReadWriter{Dialect: common.Dialect}
+	err = rw.Initialize()
+	require.NoError(t, err)
+
+	msg := &common.MessageHeartbeat{Type: common.MAV_TYPE_QUADROTOR, MavlinkVersion: 3}
+	raw := rw.GetMessage(msg.GetID()).Write(msg, true)
+
+	fr := &frame.V2Frame{Message: msg}
+
+	// the size of a frame is computed once and reused
+	require.Equal(t, 12+len(raw.Payload), a.FrameSize(fr))
+	a.ProcessReceived(&gomavlib.Channel{}, fr)
+	a.ProcessSent(&gomavlib.Channel{}, fr)
+	require.Len(t, a.sizes, 1)
+
+	for i := 0; i < sizeCacheLen+10; i++ {
+		a.FrameSize(&frame.V2Frame{Message: &common.MessageHeartbeat{}})
+	}
+	require.Len(t, a.sizes, sizeCacheLen)
+
+	cancel()
+	wg.Wait()
+}
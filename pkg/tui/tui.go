@@ -0,0 +1,257 @@
+// Package tui contains the terminal user interface.
+package tui
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bluenviron/mavp2p/pkg/bonder"
+	"github.com/bluenviron/mavp2p/pkg/errorman"
+	"github.com/bluenviron/mavp2p/pkg/loopdetector"
+	"github.com/bluenviron/mavp2p/pkg/messageman"
+	"github.com/bluenviron/mavp2p/pkg/registry"
+	"github.com/bluenviron/mavp2p/pkg/traffic"
+)
+
+const (
+	refreshPeriod = 1 * time.Second
+	maxMessages   = 15
+	maxSwitches   = 3
+	logLines      = 8
+
+	escClear = "\x1b[H\x1b[2J"
+)
+
+var timeNow = time.Now
+
+// LogBuffer is a writer that keeps the last lines written to it,
+// in order to display them inside the interface instead of scrolling the terminal.
+type LogBuffer struct {
+	mutex sync.Mutex
+	lines []string
+	buf   []byte
+}
+
+// Write implements io.Writer.
+func (b *LogBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.buf = append(b.buf, p...)
+
+	for {
+		i := bytes.IndexByte(b.buf, '\n')
+		if i < 0 {
+			break
+		}
+
+		b.lines = append(b.lines, string(b.buf[:i]))
+		b.buf = b.buf[i+1:]
+	}
+
+	if len(b.lines) > logLines {
+		b.lines = b.lines[len(b.lines)-logLines:]
+	}
+
+	return len(p), nil
+}
+
+// Lines returns the last lines written.
+func (b *LogBuffer) Lines() []string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return append([]string(nil), b.lines...)
+}
+
+// TUI is a terminal user interface that shows channels, remote nodes,
+// bonds, message rates, errors and discarded duplicates, refreshing them in place.
+type TUI struct {
+	Ctx          context.Context
+	Wg           *sync.WaitGroup
+	Writer       io.Writer
+	Version      string
+	Traffic      *traffic.Accountant
+	MessageMan   *messageman.Manager
+	Registry     *registry.Registry
+	ErrorMan     *errorman.Manager
+	Bonder       *bonder.Bonder
+	LoopDetector *loopdetector.Detector
+	LogBuffer    *LogBuffer
+
+	started time.Time
+}
+
+// Initialize initializes a TUI.
+func (t *TUI) Initialize() error {
+	t.started = timeNow()
+
+	t.Wg.Add(1)
+	go t.run()
+
+	return nil
+}
+
+func (t *TUI) run() {
+	defer t.Wg.Done()
+
+	ticker := time.NewTicker(refreshPeriod)
+	defer ticker.Stop()
+
+	for {
+		t.Writer.Write(t.render()) //nolint:errcheck
+
+		select {
+		case <-ticker.C:
+
+		case <-t.Ctx.Done():
+			return
+		}
+	}
+}
+
+// renderBonds renders members of bonds and the last switches of the active member.
+func (t *TUI) renderBonds(buf *bytes.Buffer, now time.Time) {
+	status := t.Bonder.Status()
+
+	w := tabwriter.NewWriter(buf, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "BOND\tCHANNEL\tHEALTHY\tLOSS\tACTIVE")
+	for i, g := range status {
+		for _, m := range g.Members {
+			active := ""
+			if m.Channel == g.Active {
+				active = "*"
+			}
+
+			fmt.Fprintf(w, "%d\t%s\t%t\t%.1f%%\t%s\n", i+1, m.Channel, m.Healthy, m.Loss*100, active)
+		}
+	}
+	w.Flush()
+
+	buf.WriteString("\n")
+
+	fmt.Fprintln(w, "BOND\tSWITCHED\tFROM\tTO")
+	for i, g := range status {
+		switches := g.Switches
+		if len(switches) > maxSwitches {
+			switches = switches[len(switches)-maxSwitches:]
+		}
+
+		for _, s := range slices.Backward(switches) {
+			from := s.From
+			if from == "" {
+				from = "-"
+			}
+
+			fmt.Fprintf(w, "%d\t%s ago\t%s\t%s\n", i+1, now.Sub(s.Time).Truncate(time.Second), from, s.To)
+		}
+	}
+	w.Flush()
+
+	buf.WriteString("\n")
+}
+
+func (t *TUI) render() []byte {
+	now := timeNow()
+	var buf bytes.Buffer
+
+	buf.WriteString(escClear)
+	fmt.Fprintf(&buf, "mavp2p %s - uptime %s\n", t.Version, now.Sub(t.started).Truncate(time.Second))
+	if t.LoopDetector != nil {
+		fmt.Fprintf(&buf, "duplicate frames discarded by loop detection: %d\n", t.LoopDetector.DroppedCount())
+	}
+	buf.WriteString("\n")
+
+	trafficStats := t.Traffic.Stats()
+
+	errorCounts := make(map[string]uint64)
+	for _, s := range t.ErrorMan.Stats() {
+		errorCounts[s.Channel] += s.Count
+	}
+
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "CHANNEL\tRX FRAMES/S\tRX BYTES/S\tTX FRAMES/S\tTX BYTES/S\tERRORS")
+	for _, s := range trafficStats {
+		fmt.Fprintf(w, "%s\t%.1f\t%.0f\t%.1f\t%.0f\t%d\n",
+			s.Channel,
+			s.Received.FrameRate10s, s.Received.ByteRate10s,
+			s.Sent.FrameRate10s, s.Sent.ByteRate10s,
+			errorCounts[s.Channel])
+	}
+	w.Flush()
+
+	buf.WriteString("\n")
+
+	fmt.Fprintln(w, "SYSID\tCOMPID\tTYPE\tAUTOPILOT\tARMED\tCHANNEL\tLAST SEEN")
+	for _, n := range t.MessageMan.Nodes() {
+		vehicleType, autopilot, armed := "-", "-", "-"
+		if c, ok := t.Registry.Component(n.SystemID, n.ComponentID); ok {
+			vehicleType = strings.TrimPrefix(c.Type.String(), "MAV_TYPE_")
+			autopilot = strings.TrimPrefix(c.Autopilot.String(), "MAV_AUTOPILOT_")
+			armed = strconv.FormatBool(c.Armed)
+		}
+
+		fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%s\t%s\t%s ago\n",
+			n.SystemID, n.ComponentID, vehicleType, autopilot, armed, n.Channel,
+			now.Sub(n.LastSeen).Truncate(time.Second))
+	}
+	w.Flush()
+
+	buf.WriteString("\n")
+
+	if t.Bonder != nil {
+		t.renderBonds(&buf, now)
+	}
+
+	type messageRow struct {
+		channel string
+		traffic.MessageStats
+	}
+
+	var rows []messageRow
+	for _, s := range trafficStats {
+		for _, m := range s.Messages {
+			if m.Received.FrameRate10s > 0 || m.Sent.FrameRate10s > 0 {
+				rows = append(rows, messageRow{s.Channel, m})
+			}
+		}
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		return rows[i].Received.FrameRate10s+rows[i].Sent.FrameRate10s >
+			rows[j].Received.FrameRate10s+rows[j].Sent.FrameRate10s
+	})
+
+	if len(rows) > maxMessages {
+		rows = rows[:maxMessages]
+	}
+
+	fmt.Fprintln(w, "MESSAGE\tCHANNEL\tRX/S\tTX/S\tRX BYTES/S\tTX BYTES/S")
+	for _, r := range rows {
+		fmt.Fprintf(w, "%d\t%s\t%.1f\t%.1f\t%.0f\t%.0f\n",
+			r.ID, r.channel,
+			r.Received.FrameRate10s, r.Sent.FrameRate10s,
+			r.Received.ByteRate10s, r.Sent.ByteRate10s)
+	}
+	w.Flush()
+
+	if t.LogBuffer != nil {
+		buf.WriteString("\nLOG\n")
+		for _, line := range t.LogBuffer.Lines() {
+			buf.WriteString(line + "\n")
+		}
+	}
+
+	return buf.Bytes()
+}
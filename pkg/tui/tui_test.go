@@ -0,0 +1,124 @@
+package tui
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gomavlib/v4"
+	"github.com/bluenviron/gomavlib/v4/pkg/dialects/common"
+	"github.com/bluenviron/gomavlib/v4/pkg/frame"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bluenviron/mavp2p/pkg/bonder"
+	"github.com/bluenviron/mavp2p/pkg/errorman"
+	"github.com/bluenviron/mavp2p/pkg/linkstats"
+	"github.com/bluenviron/mavp2p/pkg/loopdetector"
+	"github.com/bluenviron/mavp2p/pkg/messageman"
+	"github.com/bluenviron/mavp2p/pkg/registry"
+	"github.com/bluenviron/mavp2p/pkg/traffic"
+)
+
+func TestLogBuffer(t *testing.T) {
+	b := &LogBuffer{}
+
+	for i := 0; i < logLines+2; i++ {
+		_, err := b.Write([]byte("line" + strings.Repeat("x", i) + "\n"))
+		require.NoError(t, err)
+	}
+
+	_, err := b.Write([]byte("partial"))
+	require.NoError(t, err)
+
+	lines := b.Lines()
+	require.Len(t, lines, logLines)
+	require.Equal(t, "linexx", lines[0])
+
+	_, err = b.Write([]byte(" line\n"))
+	require.NoError(t, err)
+	require.Equal(t, "partial line", b.Lines()[logLines-1])
+}
+
+func TestRender(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	tr := &traffic.Accountant{Ctx: ctx, Wg: &wg}
+	require.NoError(t, tr.Initialize())
+
+	mm := &messageman.Manager{Ctx: ctx, Wg: &wg, StreamReqDisable: true}
+	require.NoError(t, mm.Initialize())
+
+	rg := &registry.Registry{Ctx: ctx, Wg: &wg, Timeout: time.Minute}
+	require.NoError(t, rg.Initialize())
+
+	em := &errorman.Manager{Ctx: ctx, Wg: &wg}
+	require.NoError(t, em.Initialize())
+
+	ls := &linkstats.Tracker{Ctx: ctx, Wg: &wg}
+	require.NoError(t, ls.Initialize())
+
+	bd := &bonder.Bonder{
+		Ctx:              ctx,
+		Wg:               &wg,
+		Groups:           [][]gomavlib.Endpoint{{&gomavlib.EndpointSerial{}}},
+		Policy:           bonder.PolicyFailover,
+		HeartbeatTimeout: 5 * time.Second,
+		LinkStats:        ls,
+	}
+	require.NoError(t, bd.Initialize())
+
+	ld := &loopdetector.Detector{Ctx: ctx, Wg: &wg, Window: time.Second}
+	require.NoError(t, ld.Initialize())
+
+	lb := &LogBuffer{}
+	lb.Write([]byte("level=INFO msg=\"channel opened\"\n")) //nolint:errcheck
+
+	ch := &gomavlib.Channel{}
+	tr.ProcessChannelOpen(&gomavlib.EventChannelOpen{Channel: ch})
+	evt := &gomavlib.EventFrame{
+		Frame: &frame.V2Frame{
+			SystemID:    1,
+			ComponentID: 1,
+			Message: &common.MessageHeartbeat{
+				Type:      common.MAV_TYPE_QUADROTOR,
+				Autopilot: common.MAV_AUTOPILOT_ARDUPILOTMEGA,
+				BaseMode:  common.MAV_MODE_FLAG_SAFETY_ARMED,
+			},
+		},
+		Channel: ch,
+	}
+	mm.ProcessFrame(evt)
+	rg.ProcessFrame(evt)
+
+	ld.ProcessFrame(evt)
+	ld.ProcessFrame(&gomavlib.EventFrame{Frame: evt.Frame, Channel: &gomavlib.Channel{}})
+
+	ui := &TUI{
+		Version:      "v1.2.3",
+		Traffic:      tr,
+		MessageMan:   mm,
+		Registry:     rg,
+		ErrorMan:     em,
+		Bonder:       bd,
+		LoopDetector: ld,
+		LogBuffer:    lb,
+		started:      time.Now(),
+	}
+
+	out := string(ui.render())
+	require.True(t, strings.HasPrefix(out, escClear+"mavp2p v1.2.3 - uptime 0s\n"+
+		"duplicate frames discarded by loop detection: 1\n\n"))
+	require.Contains(t, out, "CHANNEL  RX FRAMES/S")
+	require.Contains(t, out, "SYSID  COMPID")
+	require.Contains(t, out, "  true   ")
+	require.Contains(t, out, "BOND  CHANNEL")
+	require.Contains(t, out, "BOND  SWITCHED  FROM  TO")
+	require.Contains(t, out, "MESSAGE  CHANNEL")
+	require.Contains(t, out, "\nLOG\nlevel=INFO msg=\"channel opened\"\n")
+
+	cancel()
+	wg.Wait()
+}
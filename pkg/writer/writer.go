@@ -0,0 +1,303 @@
+// Package writer contains the outbound frame writer.
+package writer
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gomavlib/v4"
+	"github.com/bluenviron/gomavlib/v4/pkg/frame"
+
+	"github.com/bluenviron/mavp2p/pkg/converter"
+	"github.com/bluenviron/mavp2p/pkg/logger"
+	"github.com/bluenviron/mavp2p/pkg/traffic"
+)
+
+const (
+	defaultQueueSize = 256
+	reportPeriod     = 10 * time.Second
+)
+
+var writeFrameTo = func(n *gomavlib.Node, ch *gomavlib.Channel, fr frame.Frame) error {
+	return n.WriteFrameTo(ch, fr)
+}
+
+// Policy is the policy used when the queue of a channel is full.
+type Policy int
+
+// policies.
+const (
+	// PolicyDropOldest discards the oldest queued frame.
+	PolicyDropOldest Policy = iota
+
+	// PolicyDropNewest discards the frame that is being queued.
+	PolicyDropNewest
+
+	// PolicyDisconnect discards the queue and stops writing to the channel.
+	// The channel is returned by Disconnected, in order to be removed from routing
+	// until it is closed and opened again.
+	PolicyDisconnect
+)
+
+type queuedChannel struct {
+	channel      *gomavlib.Channel
+	cancel       func()
+	notify       chan struct{}
+	frames       []frame.Frame
+	dropped      uint64
+	reported     uint64
+	disconnected bool
+}
+
+func (qc *queuedChannel) wake() {
+	select {
+	case qc.notify <- struct{}{}:
+	default:
+	}
+}
+
+// ChannelStats are the statistics of the queue of a channel.
+type ChannelStats struct {
+	Channel      string
+	Queued       int
+	Dropped      uint64
+	Disconnected bool
+}
+
+// Writer writes outbound frames through bounded per-channel queues,
+// in order to prevent a slow channel from delaying the others.
+type Writer struct {
+	Ctx  context.Context
+	Wg   *sync.WaitGroup
+	Node *gomavlib.Node
+
+	// maximum number of frames in the queue of each channel.
+	// It defaults to 256.
+	QueueSize int
+
+	Policy Policy
+
+	// used to account sent frames. It can be nil.
+	Traffic *traffic.Accountant
+
+	// converts frames to the Mavlink version of the channel before they are sent. It can be nil.
+	Converter *converter.Converter
+
+	Log *slog.Logger
+
+	mutex          sync.Mutex
+	channels       map[*gomavlib.Channel]*queuedChannel
+	disconnected   []*gomavlib.Channel
+	disconnections uint64
+}
+
+// Initialize initializes a Writer.
+func (w *Writer) Initialize() error {
+	if w.Log == nil {
+		w.Log = slog.Default()
+	}
+
+	if w.QueueSize == 0 {
+		w.QueueSize = defaultQueueSize
+	}
+
+	w.channels = make(map[*gomavlib.Channel]*queuedChannel)
+
+	w.Wg.Add(1)
+	go w.run()
+
+	return nil
+}
+
+func (w *Writer) run() {
+	defer w.Wg.Done()
+
+	ticker := time.NewTicker(reportPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.report()
+
+		case <-w.Ctx.Done():
+			return
+		}
+	}
+}
+
+// report logs frames that have been dropped since the last report.
+func (w *Writer) report() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for _, qc := range w.channels {
+		n := qc.dropped - qc.reported
+		qc.reported = qc.dropped
+
+		if n != 0 && !qc.disconnected {
+			w.Log.Warn("write queue is full, frames dropped",
+				logger.Channel(qc.channel), slog.Uint64("count", n))
+		}
+	}
+}
+
+func (w *Writer) runChannel(ctx context.Context, qc *queuedChannel) {
+	defer w.Wg.Done()
+
+	for {
+		w.mutex.Lock()
+		var fr frame.Frame
+		if len(qc.frames) != 0 {
+			fr = qc.frames[0]
+			qc.frames[0] = nil
+			qc.frames = qc.frames[1:]
+		}
+		w.mutex.Unlock()
+
+		if fr == nil {
+			select {
+			case <-qc.notify:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		if w.Converter != nil {
+			fr = w.Converter.Convert(qc.channel, fr)
+			if fr == nil {
+				continue
+			}
+		}
+
+		err := writeFrameTo(w.Node, qc.channel, fr)
+		if err == nil && w.Traffic != nil {
+			w.Traffic.ProcessSent(qc.channel, fr)
+		}
+	}
+}
+
+// Write queues a frame for a channel. It never blocks.
+func (w *Writer) Write(ch *gomavlib.Channel, fr frame.Frame) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	qc, ok := w.channels[ch]
+	if !ok {
+		return
+	}
+
+	if qc.disconnected {
+		qc.dropped++
+		return
+	}
+
+	if len(qc.frames) >= w.QueueSize {
+		qc.dropped++
+
+		switch w.Policy {
+		case PolicyDropNewest:
+			return
+
+		case PolicyDisconnect:
+			qc.dropped += uint64(len(qc.frames))
+			clear(qc.frames)
+			qc.frames = nil
+			qc.disconnected = true
+			w.disconnected = append(w.disconnected, ch)
+			w.disconnections++
+			w.Log.Warn("write queue is full, disconnecting slow channel", logger.Channel(ch))
+			return
+		}
+
+		qc.frames[0] = nil
+		qc.frames = qc.frames[1:]
+	}
+
+	qc.frames = append(qc.frames, fr)
+	qc.wake()
+}
+
+// ProcessChannelOpen processes a EventChannelOpen.
+func (w *Writer) ProcessChannelOpen(evt *gomavlib.EventChannelOpen) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	qc := &queuedChannel{
+		channel: evt.Channel,
+		notify:  make(chan struct{}, 1),
+	}
+	w.channels[evt.Channel] = qc
+
+	var ctx context.Context
+	ctx, qc.cancel = context.WithCancel(w.Ctx)
+
+	w.Wg.Add(1)
+	go w.runChannel(ctx, qc)
+}
+
+// ProcessChannelClose processes a EventChannelClose.
+func (w *Writer) ProcessChannelClose(evt *gomavlib.EventChannelClose) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	qc, ok := w.channels[evt.Channel]
+	if !ok {
+		return
+	}
+
+	qc.cancel()
+	delete(w.channels, evt.Channel)
+
+	w.disconnected = slices.DeleteFunc(w.disconnected, func(ch *gomavlib.Channel) bool {
+		return ch == evt.Channel
+	})
+}
+
+// Disconnected returns the channels that have been disconnected
+// since the last call, because their queue was full.
+func (w *Writer) Disconnected() []*gomavlib.Channel {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	ret := w.disconnected
+	w.disconnected = nil
+	return ret
+}
+
+// Disconnections returns the number of channels that have been disconnected
+// because their queue was full.
+func (w *Writer) Disconnections() uint64 {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.disconnections
+}
+
+// Stats returns the statistics of channel queues.
+func (w *Writer) Stats() []ChannelStats {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	ret := make([]ChannelStats, 0, len(w.channels))
+
+	for _, qc := range w.channels {
+		ret = append(ret, ChannelStats{
+			Channel:      qc.channel.String(),
+			Queued:       len(qc.frames),
+			Dropped:      qc.dropped,
+			Disconnected: qc.disconnected,
+		})
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Channel < ret[j].Channel
+	})
+
+	return ret
+}
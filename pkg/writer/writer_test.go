@@ -0,0 +1,248 @@
+package writer
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gomavlib/v4"
+	"github.com/bluenviron/gomavlib/v4/pkg/dialects/common"
+	"github.com/bluenviron/gomavlib/v4/pkg/frame"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriterStalledChannel(t *testing.T) {
+	stalledCh := &gomavlib.Channel{}
+	otherCh := &gomavlib.Channel{}
+
+	for _, ca := range []struct {
+		name    string
+		policy  Policy
+		written []byte
+		stats   ChannelStats
+	}{
+		{
+			"drop oldest",
+			PolicyDropOldest,
+			[]byte{0, 6, 7, 8, 9},
+			ChannelStats{Queued: 4, Dropped: 5},
+		},
+		{
+			"drop newest",
+			PolicyDropNewest,
+			[]byte{0, 1, 2, 3, 4},
+			ChannelStats{Queued: 4, Dropped: 5},
+		},
+		{
+			"disconnect",
+			PolicyDisconnect,
+			[]byte{0},
+			ChannelStats{Dropped: 9, Disconnected: true},
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			stalled := make(chan struct{})
+			release := make(chan struct{})
+			stalledWritten := make(chan byte, 10)
+			otherWritten := make(chan byte, 10)
+
+			writeFrameTo = func(_ *gomavlib.Node, ch *gomavlib.Channel, fr frame.Frame) error {
+				if ch == stalledCh {
+					if fr.GetSequenceNumber() == 0 {
+						close(stalled)
+						<-release
+					}
+					stalledWritten <- fr.GetSequenceNumber()
+				} else {
+					otherWritten <- fr.GetSequenceNumber()
+				}
+				return nil
+			}
+			defer func() {
+				writeFrameTo = func(n *gomavlib.Node, ch *gomavlib.Channel, fr frame.Frame) error {
+					return n.WriteFrameTo(ch, fr)
+				}
+			}()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			var wg sync.WaitGroup
+
+			w := &Writer{
+				Ctx:       ctx,
+				Wg:        &wg,
+				QueueSize: 4,
+				Policy:    ca.policy,
+			}
+			err := w.Initialize()
+			require.NoError(t, err)
+
+			w.ProcessChannelOpen(&gomavlib.EventChannelOpen{Channel: stalledCh})
+			w.ProcessChannelOpen(&gomavlib.EventChannelOpen{Channel: otherCh})
+
+			write := func(seq byte) {
+				fr := &frame.V2Frame{SequenceNumber: seq, Message: &common.MessageHeartbeat{}}
+				w.Write(stalledCh, fr)
+				w.Write(otherCh, fr)
+
+				// the other channel receives every frame while the stalled one is blocked
+				select {
+				case v := <-otherWritten:
+					require.Equal(t, seq, v)
+				case <-time.After(2 * time.Second):
+					t.Fatal("timed out")
+				}
+			}
+
+			write(0)
+			<-stalled
+
+			for seq := byte(1); seq < 10; seq++ {
+				write(seq)
+			}
+
+			stats := w.Stats()
+			require.Len(t, stats, 2)
+			for _, st := range stats {
+				if st.Dropped != 0 {
+					require.Equal(t, ca.stats, st)
+				} else {
+					require.Equal(t, ChannelStats{}, st)
+				}
+			}
+
+			close(release)
+
+			for _, seq := range ca.written {
+				select {
+				case v := <-stalledWritten:
+					require.Equal(t, seq, v)
+				case <-time.After(2 * time.Second):
+					t.Fatal("timed out")
+				}
+			}
+
+			// a disconnected channel does not receive further frames
+			write(10)
+			if ca.policy == PolicyDisconnect {
+				select {
+				case <-stalledWritten:
+					t.Fatal("unexpected frame")
+				case <-time.After(50 * time.Millisecond):
+				}
+
+				require.Equal(t, uint64(1), w.Disconnections())
+				require.Equal(t, []*gomavlib.Channel{stalledCh}, w.Disconnected())
+				require.Empty(t, w.Disconnected())
+			} else {
+				require.Equal(t, byte(10), <-stalledWritten)
+				require.Equal(t, uint64(0), w.Disconnections())
+				require.Empty(t, w.Disconnected())
+			}
+
+			w.ProcessChannelClose(&gomavlib.EventChannelClose{Channel: stalledCh})
+			require.Len(t, w.Stats(), 1)
+
+			cancel()
+			wg.Wait()
+		})
+	}
+}
+
+func TestWriterStalledTCPClient(t *testing.T) {
+	node := &gomavlib.Node{
+		Endpoints: []gomavlib.Endpoint{
+			&gomavlib.EndpointTCPServer{
+				Address: "127.0.0.1:6668",
+			},
+		},
+		OutVersion:       gomavlib.V2,
+		OutSystemID:      10,
+		Dialect:          common.Dialect,
+		HeartbeatDisable: true,
+	}
+	err := node.Initialize()
+	require.NoError(t, err)
+	defer node.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	w := &Writer{
+		Ctx:       ctx,
+		Wg:        &wg,
+		Node:      node,
+		QueueSize: 16,
+	}
+	err = w.Initialize()
+	require.NoError(t, err)
+
+	// client that never reads
+	stalled, err := net.Dial("tcp", "127.0.0.1:6668")
+	require.NoError(t, err)
+	defer stalled.Close()
+
+	err = stalled.(*net.TCPConn).SetReadBuffer(4096)
+	require.NoError(t, err)
+
+	other := &gomavlib.Node{
+		Endpoints: []gomavlib.Endpoint{
+			&gomavlib.EndpointTCPClient{
+				Address: "127.0.0.1:6668",
+			},
+		},
+		OutVersion:       gomavlib.V2,
+		OutSystemID:      11,
+		Dialect:          common.Dialect,
+		HeartbeatDisable: true,
+	}
+	err = other.Initialize()
+	require.NoError(t, err)
+	defer other.Close()
+
+	var channels []*gomavlib.Channel
+	for len(channels) != 2 {
+		if evt, ok := (<-node.Events()).(*gomavlib.EventChannelOpen); ok {
+			w.ProcessChannelOpen(evt)
+			channels = append(channels, evt.Channel)
+		}
+	}
+
+	// write about 10MB, that is more than the socket buffers of the stalled client can hold.
+	// The other client receives every frame in time.
+	for i := range 40000 {
+		fr := &frame.V2Frame{
+			SequenceNumber: byte(i),
+			SystemID:       1,
+			ComponentID:    1,
+			Message:        &common.MessageFileTransferProtocol{Payload: [251]uint8{byte(i)}},
+		}
+		err = node.FixFrame(fr)
+		require.NoError(t, err)
+
+		for _, ch := range channels {
+			w.Write(ch, fr)
+		}
+
+	outer:
+		for {
+			select {
+			case evt := <-other.Events():
+				if evt, ok := evt.(*gomavlib.EventFrame); ok {
+					require.Equal(t, byte(i), evt.Frame.GetSequenceNumber())
+					break outer
+				}
+
+			case <-time.After(2 * time.Second):
+				t.Fatal("timed out")
+			}
+		}
+	}
+
+	// unblock the pending write of the stalled client
+	stalled.Close()
+
+	cancel()
+	wg.Wait()
+}